@@ -0,0 +1,33 @@
+package war
+
+import (
+	"github.com/fsnotify/fsnotify"
+)
+
+// backend delivers file system events for the directories added to it. As with
+// inotify, adding a directory does not watch its sub directories.
+type backend interface {
+	Add(dir string) error
+	Events() <-chan fsnotify.Event
+	Errors() <-chan error
+	Close() error
+}
+
+// fsnotifyBackend is the default backend, using inotify through fsnotify
+type fsnotifyBackend struct {
+	notify *fsnotify.Watcher
+}
+
+func newFsnotifyBackend() (*fsnotifyBackend, error) {
+	notify, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	return &fsnotifyBackend{notify: notify}, nil
+}
+
+func (b *fsnotifyBackend) Add(dir string) error          { return b.notify.Add(dir) }
+func (b *fsnotifyBackend) Events() <-chan fsnotify.Event { return b.notify.Events }
+func (b *fsnotifyBackend) Errors() <-chan error          { return b.notify.Errors }
+func (b *fsnotifyBackend) Close() error                  { return b.notify.Close() }
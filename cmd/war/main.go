@@ -1,37 +1,84 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
 	"os/exec"
+	"os/signal"
+	"regexp"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/doctordesh/war"
 	"github.com/doctordesh/war/colors"
+	"github.com/doctordesh/war/config"
 )
 
 const VERSION = "0.1.42-rc14"
 
 var usage = func() {
 	fmt.Println("Usage: war [options] <command-to-run>")
+	fmt.Println("       war [options] --shell '<command line>'")
+	fmt.Println("       war [options] [task...]")
+	fmt.Println()
+	fmt.Printf("Tasks are read from the first of %s found in\n", strings.Join(config.FileNames, ", "))
+	fmt.Println("the working directory. Without tasks the default tasks are run, or the only task")
+	fmt.Println("if there is just one, and --all runs all of them. Options given on the command")
+	fmt.Println("line override the tasks' settings.")
+	fmt.Println()
+	fmt.Println("The command can contain placeholders for the changed files: {file}, {relfile},")
+	fmt.Println("{dir}, {ext} and {files}. They are also available in the environment as")
+	fmt.Println("WAR_CHANGED_FILE and WAR_CHANGED_FILES. Without changed files, as on the first")
+	fmt.Println("run, arguments with placeholders are left out.")
+	fmt.Println()
 	fmt.Println("Options:")
 	flag.PrintDefaults()
 }
 
 func main() {
 	var err error
-	var environment, exclude arrayArg
-	var cwd string
-	var boring, version bool
-	var delay, ignoreChangesFor time.Duration
+	var environment, exclude, match arrayArg
+	var cwd, configPath, ignoreFile, stopSignal, onBusy, restartOnExit, readyTCP, readyHTTP, readyOutput, logFormat string
+	var all, boring, version, noGitIgnore, noKeys, poll, shell, clear, clearScrollback, exitOnSuccess, once bool
+	var delay, debounce, maxWait, pollInterval, stopTimeout, restartBackoff, timeout, readyTimeout time.Duration
+	var maxRestarts int
 
+	flag.StringVar(&configPath, "config", "", "Config file with tasks, instead of looking for war.yaml in the working directory")
+	flag.BoolVar(&all, "all", false, "Run all tasks from the config file")
 	flag.Var(&environment, "env", "Environment string with key=value pairs")
-	flag.Var(&exclude, "exclude", "Exclude changes on path, relative to the base path")
+	flag.Var(&exclude, "exclude", "Exclude changes on path or glob pattern. Without a slash it matches names at any depth, start it with / to match from the base path")
+	flag.Var(&match, "match", "Only run on changes to files matching the glob pattern, e.g. '*.go' or 'templates/**'")
 	flag.DurationVar(&delay, "delay", 0, "Time before running command")
-	flag.DurationVar(&ignoreChangesFor, "ignore-changes-for", time.Millisecond*100, "Events within the specified time will be ignored and reset the delay")
+	flag.DurationVar(&debounce, "debounce", time.Millisecond*100, "Time without changes before running, all changes within it are batched into one run")
+	flag.DurationVar(&debounce, "ignore-changes-for", time.Millisecond*100, "Deprecated: use --debounce")
+	flag.DurationVar(&maxWait, "max-wait", time.Second*2, "Longest time to postpone a run while changes keep coming in, 0 for no limit")
+	flag.StringVar(&ignoreFile, "ignore-file", "", "File with ignore rules in .gitignore syntax, read in addition to .warignore")
+	flag.BoolVar(&noGitIgnore, "no-gitignore", false, "Do not ignore changes to files matched by .gitignore files")
+	flag.BoolVar(&poll, "poll", false, "Poll for changes instead of using inotify, for file systems where inotify does not work")
+	flag.DurationVar(&pollInterval, "poll-interval", time.Millisecond*500, "Time between polls when polling for changes")
+	flag.BoolVar(&shell, "shell", false, "Run the command line with $SHELL -c, allowing pipes and '&&'")
+	flag.StringVar(&onBusy, "on-busy", string(war.BusyRestart), "What to do on changes while the command is running: restart, queue or ignore")
+	flag.DurationVar(&timeout, "timeout", 0, "Stop the command if it's still running after the duration and report it as timed out, 0 for no timeout")
+	flag.StringVar(&readyTCP, "ready-tcp", "", "Report the command as ready once the address, like localhost:8080, accepts connections")
+	flag.StringVar(&readyHTTP, "ready-http", "", "Report the command as ready once GET on the URL responds with a 2xx status")
+	flag.StringVar(&readyOutput, "ready-output", "", "Report the command as ready once a line of its output matches the regular expression")
+	flag.DurationVar(&readyTimeout, "ready-timeout", war.DefaultReadyTimeout, "Time to wait for the command to be ready before reporting it as not ready")
+	flag.StringVar(&restartOnExit, "restart-on-exit", string(war.RestartNever), "Restart the command when it exits by itself: never, always or on-failure")
+	flag.DurationVar(&restartBackoff, "restart-backoff", time.Second, "Time to wait before restarting an exited command, doubled for each restart in a row")
+	flag.IntVar(&maxRestarts, "max-restarts", 5, "Restarts in a row before giving up until files change")
+	flag.StringVar(&stopSignal, "stop-signal", "SIGINT", "Signal sent to stop the command, before escalating to SIGTERM and SIGKILL")
+	flag.DurationVar(&stopTimeout, "stop-timeout", war.DefaultStopTimeout, "Time to wait for the command to stop before escalating to the next signal")
+	flag.BoolVar(&exitOnSuccess, "exit-on-success", false, "Exit once a run succeeds, rerunning on changes until then")
+	flag.BoolVar(&once, "once", false, "Wait for the first change, run once and exit with the command's exit code")
+	flag.BoolVar(&clear, "clear", false, "Clear the terminal before each run")
+	flag.BoolVar(&clearScrollback, "clear-scrollback", false, "Clear the terminal and its scrollback before each run")
+	flag.BoolVar(&noKeys, "no-keys", false, "Do not read single key commands from the terminal, give stdin to the command instead")
 	flag.BoolVar(&boring, "boring", false, "Boring (no colors) output")
+	flag.StringVar(&logFormat, "log-format", "text", "Format of war's output on stderr: text, or json for one JSON object per event")
 	flag.BoolVar(&version, "version", false, "Print version and exit")
 
 	// usage of the program
@@ -47,45 +94,288 @@ func main() {
 	// set the coloring
 	colors.SetColoring(!boring)
 
+	var log war.Logger = colors.Default()
+	var jsonLog *colors.JSONLogger
+
+	switch logFormat {
+	case "text":
+	case "json":
+		jsonLog = colors.NewJSONLogger(os.Stderr)
+		log = jsonLog
+	default:
+		log.Errorf("unknown log format '%s', must be text or json", logFormat)
+		os.Exit(2)
+	}
+
+	cwd, err = os.Getwd()
+	if err != nil {
+		log.Errorf("%v", err)
+		os.Exit(1)
+	}
+
+	var cfg *config.Config
+	if configPath != "" {
+		cfg, err = config.Load(configPath)
+	} else {
+		cfg, err = config.Find(cwd)
+	}
+	if err != nil {
+		log.Errorf("%v", err)
+		os.Exit(2)
+	}
+
+	// Run tasks from the config file, unless a command is given
 	args := flag.Args()
-	if len(args) < 1 {
-		colors.Red("missing <command> argument")
+	tasks := []config.Task{{Command: args, Shell: shell}}
+	if cfg != nil && allTasks(cfg, args) {
+		if all && len(args) > 0 {
+			err = fmt.Errorf("--all can't be combined with task names")
+		} else if all {
+			tasks, err = cfg.All()
+		} else if len(args) == 0 {
+			tasks, err = cfg.DefaultTasks()
+			if err != nil && len(cfg.Tasks) > 1 {
+				err = fmt.Errorf("%w, or all of them with --all", err)
+			}
+		} else {
+			tasks, err = cfg.Select(args...)
+		}
+		if err != nil {
+			log.Errorf("%v", err)
+			os.Exit(2)
+		}
+
+		for _, task := range tasks {
+			log.Infof("running task '%s' from %s", task.Name, cfg.Path)
+		}
+	}
+
+	if len(tasks[0].Command) < 1 {
+		log.Errorf("missing <command> argument")
 		flag.Usage()
 		os.Exit(2)
 	}
 
-	binPath, err := exec.LookPath(args[0])
+	// Options given on the command line override the tasks' settings
+	isSet := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { isSet[f.Name] = true })
+
+	for i := range tasks {
+		task := &tasks[i]
+
+		if isSet["shell"] {
+			task.Shell = shell
+		}
+		if isSet["exclude"] {
+			task.Excludes = exclude
+		}
+		if isSet["match"] {
+			task.Matches = match
+		}
+		if isSet["delay"] || task.Delay == 0 {
+			task.Delay = delay
+		}
+		if isSet["debounce"] || isSet["ignore-changes-for"] || task.Debounce == 0 {
+			task.Debounce = debounce
+		}
+
+		// Later values win, so the --env flags override the task's environment
+		task.Env = append(task.Env, environment...)
+	}
+
+	if pollInterval <= 0 {
+		log.Errorf("invalid --poll-interval %s, must be positive", pollInterval)
+		os.Exit(2)
+	}
+
+	busyPolicy, err := war.ParseBusyPolicy(onBusy)
 	if err != nil {
-		colors.Red(err.Error())
-		os.Exit(1)
+		log.Errorf("%v", err)
+		os.Exit(2)
 	}
 
-	cwd, err = os.Getwd()
+	restartPolicy, err := war.ParseRestartPolicy(restartOnExit)
 	if err != nil {
-		colors.Red(err.Error())
-		os.Exit(1)
+		log.Errorf("%v", err)
+		os.Exit(2)
 	}
 
-	rtpl := war.RunnableTemplate{
-		BinPath:  binPath,
-		Args:     args,
-		Env:      environment,
-		Excludes: exclude,
-		Dir:      cwd,
-		Stdout:   os.Stdout,
-		Stderr:   os.Stderr,
+	sig, err := war.ParseSignal(stopSignal)
+	if err != nil {
+		log.Errorf("%v", err)
+		os.Exit(2)
+	}
+
+	var readyOutputRegexp *regexp.Regexp
+	if readyOutput != "" {
+		readyOutputRegexp, err = regexp.Compile(readyOutput)
+		if err != nil {
+			log.Errorf("invalid --ready-output: %v", err)
+			os.Exit(2)
+		}
+	}
+
+	templates := []war.RunnableTemplate{}
+	for _, task := range tasks {
+		var ready *war.ReadyCheck
+		if task.Ready != nil {
+			ready = &war.ReadyCheck{TCP: task.Ready.TCP, HTTP: task.Ready.HTTP, Output: task.Ready.Output, Timeout: task.Ready.Timeout}
+		}
+
+		// The --ready flags override the task's checks
+		if ready == nil && (readyTCP != "" || readyHTTP != "" || readyOutput != "") {
+			ready = &war.ReadyCheck{}
+		}
+		if ready != nil {
+			if isSet["ready-tcp"] {
+				ready.TCP = readyTCP
+			}
+			if isSet["ready-http"] {
+				ready.HTTP = readyHTTP
+			}
+			if isSet["ready-output"] {
+				ready.Output = readyOutputRegexp
+			}
+			if isSet["ready-timeout"] || ready.Timeout == 0 {
+				ready.Timeout = readyTimeout
+			}
+		}
+
+		binPath, err := lookPath(task.Command, task.Shell)
+		if err != nil {
+			log.Errorf("%v", err)
+			os.Exit(1)
+		}
+
+		steps := []war.RunnableTemplate{}
+		for _, step := range task.Steps {
+			stepBinPath, err := lookPath(step.Command, step.Shell)
+			if err != nil {
+				log.Errorf("%v", err)
+				os.Exit(1)
+			}
+
+			steps = append(steps, war.RunnableTemplate{
+				Name:    step.Name,
+				BinPath: stepBinPath,
+				Args:    step.Command,
+				Env:     append(step.Env, environment...),
+				Dir:     cwd,
+				Stdin:   os.Stdin,
+				Stdout:  os.Stdout,
+				Stderr:  os.Stderr,
+				Shell:   step.Shell,
+
+				StopSignal:  sig,
+				StopTimeout: stopTimeout,
+				Timeout:     timeout,
+			})
+		}
+
+		rtpl := war.RunnableTemplate{
+			Name:     task.Name,
+			BinPath:  binPath,
+			Args:     task.Command,
+			Env:      task.Env,
+			Excludes: task.Excludes,
+			Matches:  task.Matches,
+			Dir:      cwd,
+			Stdin:    os.Stdin,
+			Stdout:   os.Stdout,
+			Stderr:   os.Stderr,
+			Shell:    task.Shell,
+
+			StopSignal:  sig,
+			StopTimeout: stopTimeout,
+			Timeout:     timeout,
+
+			Ready: ready,
+			Steps: steps,
+		}
+
+		templates = append(templates, rtpl)
+	}
+
+	w := war.New(cwd, templates[0], tasks[0].Delay, tasks[0].Debounce)
+	for i := 1; i < len(tasks); i++ {
+		w.AddRunnable(templates[i], tasks[i].Delay, tasks[i].Debounce)
 	}
 
-	w := war.New(cwd, rtpl, delay, ignoreChangesFor)
 	w.Verbose = true
+	w.GitIgnore = !noGitIgnore
+	w.IgnoreFile = ignoreFile
+	w.Poll = poll
+	w.PollInterval = pollInterval
+	w.MaxWait = maxWait
+	w.OnBusy = busyPolicy
+	w.RestartOnExit = restartPolicy
+	w.RestartBackoff = restartBackoff
+	w.MaxRestarts = maxRestarts
+	w.ExitOnSuccess = exitOnSuccess
+	w.Once = once
+	w.Clear = clear
+	w.ClearScrollback = clearScrollback
+	w.Keys = !noKeys
+	w.Logger = log
+
+	if jsonLog != nil {
+		w.Hooks = []war.Hooks{war.EventHooks{Logger: jsonLog}}
+	}
+
+	// Stop on ctrl-c
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT)
+
+	go func() {
+		<-sigs
+		if jsonLog == nil {
+			fmt.Fprintln(os.Stderr)
+		}
+		log.Infof("keyboard interrupt detected, quiting...")
+		cancel()
+	}()
+
+	err = w.Run(ctx)
+
+	var exitErr *war.ExitError
+	if errors.As(err, &exitErr) {
+		if exitErr.Code < 0 {
+			os.Exit(1)
+		}
+		os.Exit(exitErr.Code)
+	}
 
-	err = w.WatchAndRun()
 	if err != nil {
-		colors.Red("could not start war: %v", err)
+		log.Errorf("war stopped: %v", err)
 		os.Exit(2)
 	}
 }
 
+// lookPath returns the path of the command's executable. In shell mode the
+// shell looks up the command, so the path is empty.
+func lookPath(command []string, shell bool) (string, error) {
+	if shell {
+		return "", nil
+	}
+
+	return exec.LookPath(command[0])
+}
+
+// allTasks returns true if all args are names of tasks in the config, or if
+// there are no args
+func allTasks(cfg *config.Config, args []string) bool {
+	for _, a := range args {
+		if cfg.Has(a) == false {
+			return false
+		}
+	}
+
+	return true
+}
+
 // arrayArg is a type to be able to pass multiple flags of the same name, and
 // get them in a list. Only works with strings
 type arrayArg []string
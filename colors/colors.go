@@ -2,28 +2,62 @@ package colors
 
 import (
 	"fmt"
+	"io"
+	"os"
 
 	"github.com/logrusorgru/aurora"
 )
 
-var au = aurora.NewAurora(true)
+// Logger prints messages prefixed with [WAR], colored by their level
+type Logger struct {
+	w  io.Writer
+	au aurora.Aurora
+}
+
+// NewLogger returns a Logger writing to w, in color if coloring is true
+func NewLogger(w io.Writer, coloring bool) *Logger {
+	return &Logger{w: w, au: aurora.NewAurora(coloring)}
+}
+
+var std = NewLogger(os.Stderr, true)
+
+// Default returns the Logger used by the package functions, writing to stderr
+func Default() *Logger {
+	return std
+}
 
 func SetColoring(b bool) {
-	au = aurora.NewAurora(b)
+	std.au = aurora.NewAurora(b)
+}
+
+func (l *Logger) Infof(str string, parts ...interface{}) {
+	fmt.Fprintf(l.w, "%s %s\n", l.au.Cyan("[WAR]"), fmt.Sprintf(str, parts...))
+}
+
+func (l *Logger) Successf(str string, parts ...interface{}) {
+	fmt.Fprintf(l.w, "%s %s\n", l.au.Green("[WAR]"), fmt.Sprintf(str, parts...))
+}
+
+func (l *Logger) Warnf(str string, parts ...interface{}) {
+	fmt.Fprintf(l.w, "%s %s\n", l.au.Yellow("[WAR]"), fmt.Sprintf(str, parts...))
+}
+
+func (l *Logger) Errorf(str string, parts ...interface{}) {
+	fmt.Fprintf(l.w, "%s %s\n", l.au.Red("[WAR]"), fmt.Sprintf(str, parts...))
 }
 
 func Yellow(str string, parts ...interface{}) {
-	fmt.Printf("%s %s\n", au.Yellow("[WAR]"), fmt.Sprintf(str, parts...))
+	std.Warnf(str, parts...)
 }
 
 func Red(str string, parts ...interface{}) {
-	fmt.Printf("%s %s\n", au.Red("[WAR]"), fmt.Sprintf(str, parts...))
+	std.Errorf(str, parts...)
 }
 
 func Blue(str string, parts ...interface{}) {
-	fmt.Printf("%s %s\n", au.Cyan("[WAR]"), fmt.Sprintf(str, parts...))
+	std.Infof(str, parts...)
 }
 
 func Green(str string, parts ...interface{}) {
-	fmt.Printf("%s %s\n", au.Green("[WAR]"), fmt.Sprintf(str, parts...))
+	std.Successf(str, parts...)
 }
@@ -0,0 +1,58 @@
+package colors
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// JSONLogger writes messages and events as one JSON object per line, for
+// other programs to read
+type JSONLogger struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// NewJSONLogger returns a JSONLogger writing to w
+func NewJSONLogger(w io.Writer) *JSONLogger {
+	return &JSONLogger{enc: json.NewEncoder(w)}
+}
+
+// Event writes the event with the fields, and its time
+func (l *JSONLogger) Event(event string, fields map[string]interface{}) {
+	obj := map[string]interface{}{}
+	for k, v := range fields {
+		obj[k] = v
+	}
+
+	obj["event"] = event
+	obj["time"] = time.Now().Format(time.RFC3339Nano)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// There is nowhere to report a failed write
+	_ = l.enc.Encode(obj)
+}
+
+func (l *JSONLogger) message(level string, str string, parts ...interface{}) {
+	l.Event("log", map[string]interface{}{"level": level, "message": fmt.Sprintf(str, parts...)})
+}
+
+func (l *JSONLogger) Infof(str string, parts ...interface{}) {
+	l.message("info", str, parts...)
+}
+
+func (l *JSONLogger) Successf(str string, parts ...interface{}) {
+	l.message("success", str, parts...)
+}
+
+func (l *JSONLogger) Warnf(str string, parts ...interface{}) {
+	l.message("warn", str, parts...)
+}
+
+func (l *JSONLogger) Errorf(str string, parts ...interface{}) {
+	l.message("error", str, parts...)
+}
@@ -0,0 +1,433 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// FileNames are the config files looked for by Find, in order
+var FileNames = []string{"war.yaml", "war.yml", ".war.yaml", ".war.yml", "war.toml", ".war.toml"}
+
+var ErrNoTasks = errors.New("config has no tasks")
+
+// Config is a project's war configuration with one or more named tasks
+type Config struct {
+	Path string
+
+	// Default are the names of the tasks to run when none are given
+	Default []string
+	Tasks   map[string]Task
+}
+
+// Task is a command and the settings to watch and run it with
+type Task struct {
+	Name string
+
+	// Command is the command and its arguments. A command given as a single
+	// string in the config file is a command line to run with the shell.
+	Command []string
+	Shell   bool
+
+	Env      []string
+	Excludes []string
+	Matches  []string
+
+	// Delay and Debounce are zero when not set in the config file
+	Delay    time.Duration
+	Debounce time.Duration
+
+	// Steps are run in order before the command, the command is only run if
+	// they all succeed. They are the task's dependencies, see DependsOn, then
+	// the task's own steps.
+	Steps []Step
+
+	// DependsOn are the names of tasks whose commands are run as steps
+	// before this task
+	DependsOn []string
+
+	// Ready, if set, tells when the task's command is ready
+	Ready *Ready
+}
+
+// Ready are the checks that tell when a started command, like a server, is
+// ready, see war.ReadyCheck. Timeout is zero when not set in the config file.
+type Ready struct {
+	TCP     string
+	HTTP    string
+	Output  *regexp.Regexp
+	Timeout time.Duration
+}
+
+// Step is a command that must succeed before a task's command is run
+type Step struct {
+	Name    string
+	Command []string
+	Shell   bool
+	Env     []string
+}
+
+// file is the format of the config file, shared between YAML and TOML
+type file struct {
+	Default interface{}     `yaml:"default" toml:"default"`
+	Tasks   map[string]task `yaml:"tasks" toml:"tasks"`
+}
+
+type task struct {
+	Command  interface{}       `yaml:"command" toml:"command"`
+	Shell    bool              `yaml:"shell" toml:"shell"`
+	Env      map[string]string `yaml:"env" toml:"env"`
+	Exclude  []string          `yaml:"exclude" toml:"exclude"`
+	Match    []string          `yaml:"match" toml:"match"`
+	Delay    string            `yaml:"delay" toml:"delay"`
+	Debounce string            `yaml:"debounce" toml:"debounce"`
+
+	Steps     []interface{} `yaml:"steps" toml:"steps"`
+	DependsOn []string      `yaml:"depends_on" toml:"depends_on"`
+
+	Ready *ready `yaml:"ready" toml:"ready"`
+}
+
+type ready struct {
+	TCP     string `yaml:"tcp" toml:"tcp"`
+	HTTP    string `yaml:"http" toml:"http"`
+	Output  string `yaml:"output" toml:"output"`
+	Timeout string `yaml:"timeout" toml:"timeout"`
+}
+
+// Find looks for one of the FileNames in dir and loads it. Returns nil and no
+// error if there is no config file.
+func Find(dir string) (*Config, error) {
+	for _, name := range FileNames {
+		path := filepath.Join(dir, name)
+
+		_, err := os.Stat(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		return Load(path)
+	}
+
+	return nil, nil
+}
+
+// Load reads the config file at path, as TOML if it has the .toml extension
+// and as YAML otherwise
+func Load(path string) (*Config, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read config: %w", err)
+	}
+
+	f := file{}
+	if filepath.Ext(path) == ".toml" {
+		err = toml.Unmarshal(b, &f)
+	} else {
+		err = yaml.Unmarshal(b, &f)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not parse config %s: %w", path, err)
+	}
+
+	c := &Config{Path: path, Tasks: map[string]Task{}}
+	for name, t := range f.Tasks {
+		c.Tasks[name], err = t.task(name)
+		if err != nil {
+			return nil, fmt.Errorf("invalid task '%s' in %s: %w", name, path, err)
+		}
+	}
+
+	err = c.resolveDependencies()
+	if err != nil {
+		return nil, fmt.Errorf("invalid dependencies in %s: %w", path, err)
+	}
+
+	c.Default, err = stringOrList(f.Default)
+	if err != nil {
+		return nil, fmt.Errorf("invalid default in %s: %w", path, err)
+	}
+
+	for _, name := range c.Default {
+		if c.Has(name) == false {
+			return nil, fmt.Errorf("default task '%s' in %s does not exist", name, path)
+		}
+	}
+
+	return c, nil
+}
+
+// Has returns true if there's a task with the name
+func (c *Config) Has(name string) bool {
+	_, ok := c.Tasks[name]
+	return ok
+}
+
+// Names returns the names of all tasks, sorted
+func (c *Config) Names() []string {
+	names := make([]string, 0, len(c.Tasks))
+	for name := range c.Tasks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Task returns the task with the name
+func (c *Config) Task(name string) (Task, error) {
+	t, ok := c.Tasks[name]
+	if !ok {
+		return Task{}, fmt.Errorf("no task named '%s' in %s, available tasks: %s", name, c.Path, strings.Join(c.Names(), ", "))
+	}
+
+	return t, nil
+}
+
+// DefaultTasks returns the tasks named by 'default', or the only task if there
+// is just one. Use All to run several tasks without a default.
+func (c *Config) DefaultTasks() ([]Task, error) {
+	if len(c.Default) > 0 {
+		return c.Select(c.Default...)
+	}
+
+	switch len(c.Tasks) {
+	case 0:
+		return nil, ErrNoTasks
+	case 1:
+		return c.All()
+	}
+
+	return nil, fmt.Errorf("%s has several tasks and no default, pick one or more of: %s", c.Path, strings.Join(c.Names(), ", "))
+}
+
+// All returns all tasks, sorted by name
+func (c *Config) All() ([]Task, error) {
+	if len(c.Tasks) == 0 {
+		return nil, ErrNoTasks
+	}
+
+	return c.Select(c.Names()...)
+}
+
+// Select returns the tasks with the names
+func (c *Config) Select(names ...string) ([]Task, error) {
+	tasks := []Task{}
+	for _, name := range names {
+		t, err := c.Task(name)
+		if err != nil {
+			return nil, err
+		}
+
+		tasks = append(tasks, t)
+	}
+
+	return tasks, nil
+}
+
+func (t task) task(name string) (Task, error) {
+	var err error
+
+	res := Task{
+		Name:     name,
+		Shell:    t.Shell,
+		Excludes: t.Exclude,
+		Matches:  t.Match,
+	}
+
+	// A single string is a command line for the shell
+	if _, ok := t.Command.(string); ok {
+		res.Shell = true
+	}
+
+	res.Command, err = stringOrList(t.Command)
+	if err != nil {
+		return res, fmt.Errorf("invalid command: %w", err)
+	}
+
+	if len(res.Command) == 0 || res.Command[0] == "" {
+		return res, fmt.Errorf("missing command")
+	}
+
+	keys := make([]string, 0, len(t.Env))
+	for k := range t.Env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		res.Env = append(res.Env, k+"="+t.Env[k])
+	}
+
+	for i, step := range t.Steps {
+		command, err := stringOrList(step)
+		if err != nil || len(command) == 0 {
+			return res, fmt.Errorf("invalid step %d: must be a string or a list of strings", i+1)
+		}
+
+		_, shell := step.(string)
+		res.Steps = append(res.Steps, Step{Command: command, Shell: shell})
+	}
+
+	res.DependsOn = t.DependsOn
+
+	if t.Delay != "" {
+		res.Delay, err = time.ParseDuration(t.Delay)
+		if err != nil {
+			return res, fmt.Errorf("invalid delay: %w", err)
+		}
+	}
+
+	if t.Debounce != "" {
+		res.Debounce, err = time.ParseDuration(t.Debounce)
+		if err != nil {
+			return res, fmt.Errorf("invalid debounce: %w", err)
+		}
+	}
+
+	if t.Ready != nil {
+		res.Ready, err = t.Ready.ready()
+		if err != nil {
+			return res, fmt.Errorf("invalid ready: %w", err)
+		}
+	}
+
+	return res, nil
+}
+
+func (r ready) ready() (*Ready, error) {
+	var err error
+
+	if r.TCP == "" && r.HTTP == "" && r.Output == "" {
+		return nil, fmt.Errorf("must have one of tcp, http or output")
+	}
+
+	res := &Ready{TCP: r.TCP, HTTP: r.HTTP}
+
+	if r.Output != "" {
+		res.Output, err = regexp.Compile(r.Output)
+		if err != nil {
+			return nil, fmt.Errorf("invalid output: %w", err)
+		}
+	}
+
+	if r.Timeout != "" {
+		res.Timeout, err = time.ParseDuration(r.Timeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timeout: %w", err)
+		}
+	}
+
+	return res, nil
+}
+
+// stringOrList converts a value decoded as either a string or a list of
+// strings into a list
+func stringOrList(v interface{}) ([]string, error) {
+	switch v := v.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return []string{v}, nil
+	case []interface{}:
+		list := []string{}
+		for _, e := range v {
+			s, ok := e.(string)
+			if !ok {
+				return nil, fmt.Errorf("must be a string or a list of strings")
+			}
+			list = append(list, s)
+		}
+
+		return list, nil
+	}
+
+	return nil, fmt.Errorf("must be a string or a list of strings")
+}
+
+// resolveDependencies prepends the commands of the tasks each task depends on
+// to its steps, dependencies first. A task that is depended on several times
+// is only run once.
+func (c *Config) resolveDependencies() error {
+	resolved := map[string]Task{}
+
+	var resolve func(name string, visiting []string) ([]Step, error)
+	resolve = func(name string, visiting []string) ([]Step, error) {
+		for _, v := range visiting {
+			if v == name {
+				return nil, fmt.Errorf("dependency cycle: %s -> %s", strings.Join(visiting, " -> "), name)
+			}
+		}
+		visiting = append(visiting, name)
+
+		t, ok := c.Tasks[name]
+		if !ok {
+			return nil, fmt.Errorf("task '%s' depends on unknown task '%s'", visiting[len(visiting)-2], name)
+		}
+
+		if r, ok := resolved[name]; ok {
+			return r.Steps, nil
+		}
+
+		steps := []Step{}
+		for _, dep := range t.DependsOn {
+			depSteps, err := resolve(dep, visiting)
+			if err != nil {
+				return nil, err
+			}
+
+			d := c.Tasks[dep]
+			steps = append(steps, depSteps...)
+			steps = append(steps, Step{Name: d.Name, Command: d.Command, Shell: d.Shell, Env: d.Env})
+		}
+
+		if len(steps) > 0 {
+			t.Steps = append(dedupeSteps(steps), t.Steps...)
+		}
+		resolved[name] = t
+
+		return t.Steps, nil
+	}
+
+	for _, name := range c.Names() {
+		_, err := resolve(name, nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	for name, t := range resolved {
+		c.Tasks[name] = t
+	}
+
+	return nil
+}
+
+// dedupeSteps removes all but the first of the steps running the same task
+func dedupeSteps(steps []Step) []Step {
+	seen := map[string]bool{}
+	res := []Step{}
+
+	for _, s := range steps {
+		if s.Name != "" && seen[s.Name] {
+			continue
+		}
+
+		seen[s.Name] = true
+		res = append(res, s)
+	}
+
+	return res
+}
@@ -0,0 +1,230 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/doctordesh/check"
+)
+
+func write(t *testing.T, dir, name, content string) string {
+	t.Helper()
+
+	path := filepath.Join(dir, name)
+	err := os.WriteFile(path, []byte(content), 0666)
+	check.OK(t, err)
+
+	return path
+}
+
+func TestLoadYAML(t *testing.T) {
+	dir := t.TempDir()
+	write(t, dir, "war.yaml", `
+default: server
+tasks:
+  server:
+    command: go run ./cmd/server
+    env:
+      PORT: "8080"
+      DEBUG: "1"
+    exclude: [node_modules]
+    match: ["*.go", "templates/**"]
+    delay: 100ms
+    debounce: 250ms
+  test:
+    command: [go, test, ./...]
+`)
+
+	c, err := Find(dir)
+	check.OK(t, err)
+	check.Equals(t, []string{"server", "test"}, c.Names())
+
+	tasks, err := c.DefaultTasks()
+	check.OK(t, err)
+	check.Equals(t, 1, len(tasks))
+	check.Equals(t, Task{
+		Name:     "server",
+		Command:  []string{"go run ./cmd/server"},
+		Shell:    true,
+		Env:      []string{"DEBUG=1", "PORT=8080"},
+		Excludes: []string{"node_modules"},
+		Matches:  []string{"*.go", "templates/**"},
+		Delay:    time.Millisecond * 100,
+		Debounce: time.Millisecond * 250,
+	}, tasks[0])
+
+	task, err := c.Task("test")
+	check.OK(t, err)
+	check.Equals(t, []string{"go", "test", "./..."}, task.Command)
+	check.Equals(t, false, task.Shell)
+
+	_, err = c.Task("missing")
+	check.NotOK(t, err)
+}
+
+func TestLoadTOML(t *testing.T) {
+	dir := t.TempDir()
+	write(t, dir, ".war.toml", `
+[tasks.test]
+command = ["go", "test", "./..."]
+match = ["*.go"]
+debounce = "1s"
+`)
+
+	c, err := Find(dir)
+	check.OK(t, err)
+
+	tasks, err := c.DefaultTasks()
+	check.OK(t, err)
+	check.Equals(t, 1, len(tasks))
+
+	task := tasks[0]
+	check.Equals(t, "test", task.Name)
+	check.Equals(t, []string{"go", "test", "./..."}, task.Command)
+	check.Equals(t, []string{"*.go"}, task.Matches)
+	check.Equals(t, time.Second, task.Debounce)
+}
+
+func TestFindWithoutConfig(t *testing.T) {
+	c, err := Find(t.TempDir())
+	check.OK(t, err)
+	check.Assert(t, c == nil)
+}
+
+func TestInvalidConfigs(t *testing.T) {
+	table := []string{
+		"tasks:\n  a:\n    delay: 1s\n",
+		"tasks:\n  a:\n    command: [go, 1]\n",
+		"tasks:\n  a:\n    command: go test\n    delay: soon\n",
+		"default: b\ntasks:\n  a:\n    command: go test\n",
+		"tasks: [",
+		"tasks:\n  a:\n    command: a\n    ready:\n      timeout: 1s\n",
+		"tasks:\n  a:\n    command: a\n    ready:\n      output: '('\n",
+		"tasks:\n  a:\n    command: a\n    ready:\n      tcp: localhost:8080\n      timeout: soon\n",
+	}
+
+	for _, content := range table {
+		path := write(t, t.TempDir(), "war.yaml", content)
+		_, err := Load(path)
+		check.NotOKWithMessage(t, err, "for config %q", content)
+	}
+}
+
+func TestDefaultTaskWithSeveralTasks(t *testing.T) {
+	path := write(t, t.TempDir(), "war.yaml", "tasks:\n  a:\n    command: a\n  b:\n    command: b\n")
+
+	c, err := Load(path)
+	check.OK(t, err)
+
+	_, err = c.DefaultTasks()
+	check.NotOK(t, err)
+
+	tasks, err := c.All()
+	check.OK(t, err)
+	check.Equals(t, 2, len(tasks))
+}
+
+func TestDefaultTasks(t *testing.T) {
+	content := "tasks:\n  a:\n    command: a\n  b:\n    command: b\n  c:\n    command: c\n"
+
+	type row struct {
+		Default  string
+		Expected []string
+	}
+
+	table := []row{
+		{"default: b\n", []string{"b"}},
+		{"default: [c, a]\n", []string{"c", "a"}},
+	}
+
+	for _, row := range table {
+		path := write(t, t.TempDir(), "war.yaml", row.Default+content)
+
+		c, err := Load(path)
+		check.OK(t, err)
+
+		tasks, err := c.DefaultTasks()
+		check.OK(t, err)
+
+		names := []string{}
+		for _, task := range tasks {
+			names = append(names, task.Name)
+		}
+		check.EqualsWithMessage(t, row.Expected, names, "for default %q", row.Default)
+	}
+}
+
+func TestStepsAndDependencies(t *testing.T) {
+	path := write(t, t.TempDir(), "war.yaml", `
+tasks:
+  generate:
+    command: go generate ./...
+  build:
+    command: [go, build, -o, bin/server, ./cmd/server]
+    depends_on: [generate]
+  server:
+    command: [./bin/server]
+    depends_on: [generate, build]
+    steps:
+      - go vet ./...
+`)
+
+	c, err := Load(path)
+	check.OK(t, err)
+
+	task, err := c.Task("server")
+	check.OK(t, err)
+	check.Equals(t, []Step{
+		{Name: "generate", Command: []string{"go generate ./..."}, Shell: true},
+		{Name: "build", Command: []string{"go", "build", "-o", "bin/server", "./cmd/server"}},
+		{Command: []string{"go vet ./..."}, Shell: true},
+	}, task.Steps)
+
+	task, err = c.Task("generate")
+	check.OK(t, err)
+	check.Equals(t, 0, len(task.Steps))
+}
+
+func TestDependencyErrors(t *testing.T) {
+	table := []string{
+		"tasks:\n  a:\n    command: a\n    depends_on: [b]\n  b:\n    command: b\n    depends_on: [a]\n",
+		"tasks:\n  a:\n    command: a\n    depends_on: [a]\n",
+		"tasks:\n  a:\n    command: a\n    depends_on: [missing]\n",
+		"tasks:\n  a:\n    command: a\n    steps: [[go, 1]]\n",
+	}
+
+	for _, content := range table {
+		path := write(t, t.TempDir(), "war.yaml", content)
+		_, err := Load(path)
+		check.NotOKWithMessage(t, err, "for config %q", content)
+	}
+}
+
+func TestReady(t *testing.T) {
+	path := write(t, t.TempDir(), "war.yaml", `
+tasks:
+  server:
+    command: go run ./cmd/server
+    ready:
+      http: http://localhost:8080/health
+      output: listening on
+      timeout: 10s
+  test:
+    command: go test ./...
+`)
+
+	c, err := Load(path)
+	check.OK(t, err)
+
+	task, err := c.Task("server")
+	check.OK(t, err)
+	check.Equals(t, "http://localhost:8080/health", task.Ready.HTTP)
+	check.Equals(t, "listening on", task.Ready.Output.String())
+	check.Equals(t, time.Second*10, task.Ready.Timeout)
+
+	task, err = c.Task("test")
+	check.OK(t, err)
+	check.Assert(t, task.Ready == nil)
+}
@@ -7,6 +7,7 @@ import (
 	"path/filepath"
 	"strings"
 
+	"github.com/bmatcuk/doublestar/v4"
 	"github.com/fsnotify/fsnotify"
 )
 
@@ -23,50 +24,98 @@ var ErrDifferentBasePaths = errors.New("path must be relative to base")
 // 	"bin",
 // }
 
-func DecideAction(event fsnotify.Event, basePath string, excludedPaths, excludedPathParts []string, disk fs.FS) (Action, error) {
+func DecideAction(event fsnotify.Event, basePath string, excludedPaths, excludedPathParts, matchPatterns []string, ignorer Ignorer, disk fs.FS) (Action, error) {
+	act, _, _, err := decideAction(event, basePath, excludedPaths, excludedPathParts, matchPatterns, ignorer, disk)
+	return act, err
+}
+
+// Reasons for ignoring events, as given to Hooks.OnIgnored
+const (
+	ReasonChmod       = "chmod"
+	ReasonRemoved     = "removed"
+	ReasonExcluded    = "excluded"
+	ReasonTempFile    = "editor temp file"
+	ReasonIgnoreFile  = "ignore file"
+	ReasonDirectory   = "directory"
+	ReasonNotMatching = "not matching"
+)
+
+// decideAction is DecideAction, also returning why an event is ignored, and
+// the rule that ignores it with where it's from, like 'sub/.gitignore: *.log',
+// if it's ignored by an exclude or ignore file rule
+func decideAction(event fsnotify.Event, basePath string, excludedPaths, excludedPathParts, matchPatterns []string, ignorer Ignorer, disk fs.FS) (Action, string, string, error) {
 	if event.Op&fsnotify.Chmod == fsnotify.Chmod {
-		return ActionIgnore, nil
+		return ActionIgnore, ReasonChmod, "", nil
 	}
 
 	if event.Op&fsnotify.Remove == fsnotify.Remove {
-		return ActionIgnore, nil
+		return ActionIgnore, ReasonRemoved, "", nil
 	}
 
 	path := filepath.Clean(event.Name)
 	if filepath.IsAbs(path) == false {
-		return ActionIgnore, ErrIsRelative
+		return ActionIgnore, "", "", ErrIsRelative
 	}
 
 	if strings.HasPrefix(path, basePath) == false {
-		return ActionIgnore, ErrDifferentBasePaths
+		return ActionIgnore, "", "", ErrDifferentBasePaths
 	}
 
 	relPath, err := filepath.Rel(basePath, path)
 	if err != nil {
-		return ActionIgnore, fmt.Errorf("could not find relative path between '%s' and '%s': %w", basePath, event.Name, err)
+		return ActionIgnore, "", "", fmt.Errorf("could not find relative path between '%s' and '%s': %w", basePath, event.Name, err)
 	}
 
 	if matchOneOf(relPath, excludedPathParts) {
-		return ActionIgnore, nil
+		return ActionIgnore, ReasonExcluded, "", nil
 	}
 
-	if matchSubPath(relPath, excludedPaths) {
-		return ActionIgnore, nil
+	if isEmacsTempFile(relPath) {
+		return ActionIgnore, ReasonTempFile, "", nil
 	}
 
-	if isEmacsTempFile(relPath) {
-		return ActionIgnore, nil
+	dir, err := isDir(relPath, disk)
+	if err != nil {
+		return ActionIgnore, "", "", err
 	}
 
-	if isDir(relPath, disk) {
+	if ignored, rule := excludeRules(excludedPaths).ignores(relPath, dir); ignored {
+		return ActionIgnore, ReasonExcluded, rule.String(), nil
+	}
+
+	if ignorer != nil && ignorer.Ignore(relPath, dir) {
+		return ActionIgnore, ReasonIgnoreFile, ignoredBy(ignorer, relPath, dir), nil
+	}
+
+	if dir {
 		if event.Op != fsnotify.Create {
-			return ActionIgnore, nil
+			return ActionIgnore, ReasonDirectory, "", nil
 		}
 
-		return ActionAdd, nil
+		return ActionAdd, "", "", nil
+	}
+
+	if matchPattern(relPath, matchPatterns) == false {
+		return ActionIgnore, ReasonNotMatching, "", nil
+	}
+
+	return ActionRun, "", "", nil
+}
+
+// ignoredBy returns the rule the ignorer ignores the path by, empty if it
+// can't tell
+func ignoredBy(ignorer Ignorer, relPath string, isDir bool) string {
+	r, ok := ignorer.(ruleIgnorer)
+	if !ok {
+		return ""
+	}
+
+	rule := r.ignoredBy(relPath, isDir)
+	if rule == nil {
+		return ""
 	}
 
-	return ActionRun, nil
+	return rule.String()
 }
 
 // matchOneOf takes a path and a list pf pathParts. If one if the pathParts
@@ -87,10 +136,27 @@ func matchOneOf(path string, pathParts []string) bool {
 	return matchOneOf(filepath.Clean(d), pathParts)
 }
 
-// matchSubPath returns true if a value in paths is a subpath to path
-func matchSubPath(path string, paths []string) bool {
-	for _, p := range paths {
-		if strings.HasPrefix(path, p) {
+// matchPattern returns true if path matches one of the glob patterns, or if
+// there are no patterns at all. Patterns without a '/' are matched against the
+// file name only, so '*.go' matches at any depth, while patterns with a '/'
+// are matched against the whole path relative to the base.
+func matchPattern(path string, patterns []string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+
+	path = filepath.ToSlash(path)
+	base := filepath.Base(path)
+
+	for _, p := range patterns {
+		p = strings.TrimPrefix(filepath.ToSlash(p), "/")
+
+		name := path
+		if strings.Contains(p, "/") == false {
+			name = base
+		}
+
+		if ok, _ := doublestar.Match(p, name); ok {
 			return true
 		}
 	}
@@ -98,20 +164,20 @@ func matchSubPath(path string, paths []string) bool {
 	return false
 }
 
-// isDir return true if the path is considered a directory according to the fs.FS
-func isDir(path string, disk fs.FS) bool {
-	file, err := disk.Open(path)
-	if err != nil {
-		fmt.Println(err)
-		return false
+// isDir return true if the path is considered a directory according to the
+// fs.FS. A path that no longer exists, like a file that was moved away, is not
+// a directory.
+func isDir(path string, disk fs.FS) (bool, error) {
+	fileInfo, err := fs.Stat(disk, path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
 	}
 
-	fileInfo, err := file.Stat()
 	if err != nil {
-		return false
+		return false, fmt.Errorf("could not stat '%s': %w", path, err)
 	}
 
-	return fileInfo.IsDir()
+	return fileInfo.IsDir(), nil
 }
 
 func isEmacsTempFile(path string) bool {
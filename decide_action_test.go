@@ -1,8 +1,9 @@
 package war
 
 import (
-	"fmt"
 	"io/fs"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -16,7 +17,7 @@ func TestIgnoreCmhods(t *testing.T) {
 	var err error
 
 	event := fsnotify.Event{Name: "not important", Op: fsnotify.Chmod}
-	act, err := DecideAction(event, "", nil, nil, disk)
+	act, err := DecideAction(event, "", nil, nil, nil, nil, disk)
 
 	check.OK(t, err)
 	check.Assert(t, act == ActionIgnore)
@@ -26,7 +27,7 @@ func TestIgnoreRemovals(t *testing.T) {
 	var err error
 
 	event := fsnotify.Event{Name: "not important", Op: fsnotify.Remove}
-	act, err := DecideAction(event, "", nil, nil, disk)
+	act, err := DecideAction(event, "", nil, nil, nil, nil, disk)
 
 	check.OK(t, err)
 	check.Assert(t, act == ActionIgnore)
@@ -36,7 +37,7 @@ func TestEventPathMustBeAbsolute(t *testing.T) {
 	_, err := DecideAction(
 		fsnotify.Event{Name: "local/path", Op: fsnotify.Create},
 		"",
-		nil, nil,
+		nil, nil, nil, nil,
 		disk,
 	)
 
@@ -48,7 +49,7 @@ func TestMustBePartOfBasePath(t *testing.T) {
 	_, err = DecideAction(
 		fsnotify.Event{Name: "/absolute/but/wrong/base", Op: fsnotify.Create},
 		"/absolute/with/right",
-		nil, nil,
+		nil, nil, nil, nil,
 		disk,
 	)
 
@@ -57,7 +58,7 @@ func TestMustBePartOfBasePath(t *testing.T) {
 	_, err = DecideAction(
 		fsnotify.Event{Name: "/absolute/with/right/base", Op: fsnotify.Create},
 		"/absolute/with/right",
-		nil, nil,
+		nil, nil, nil, nil,
 		disk,
 	)
 
@@ -91,6 +92,8 @@ func TestIgnoreBasedOnParts(t *testing.T) {
 			base,
 			nil,
 			[]string{".git"},
+			nil,
+			nil,
 			disk,
 		)
 		check.OKWithMessage(t, err, "for path %s", row.ChangeAtPath)
@@ -108,7 +111,21 @@ func TestIgnoresBasedOnPaths(t *testing.T) {
 	base := "/proj/"
 	table := []row{
 		{base + "bin/executable", []string{"bin"}, true},
-		{base + "sub/bin/executable", []string{"bin"}, false},
+		{base + "sub/bin/executable", []string{"bin"}, true},
+		{base + "sub/bin/executable", []string{"/bin"}, false},
+		{base + "bin/executable", []string{"/bin"}, true},
+		{base + "binary_test.go", []string{"bin"}, false},
+		{base + "bin", []string{"bin"}, true},
+		{base + "sub/bin/executable", []string{"sub/bin"}, true},
+		{base + "build/main.o", []string{"build/*.o"}, true},
+		{base + "build/main.go", []string{"build/*.o"}, false},
+		{base + "gen/deep/x.pb.go", []string{"gen/**/*.pb.go"}, true},
+		{base + "x.log", []string{"*.log"}, true},
+		{base + "sub/x.log", []string{"*.log"}, true},
+		{base + "sub/deep/x.log", []string{"*.log"}, true},
+		{base + "sub/x.log", []string{"/*.log"}, false},
+		{base + "sub/build/main.o", []string{"build/*.o"}, false},
+		{base + "sub/build/main.o", []string{"**/build/*.o"}, true},
 	}
 
 	for _, row := range table {
@@ -117,6 +134,8 @@ func TestIgnoresBasedOnPaths(t *testing.T) {
 			base,
 			row.ExcludePaths,
 			nil,
+			nil,
+			nil,
 			disk,
 		)
 
@@ -125,6 +144,80 @@ func TestIgnoresBasedOnPaths(t *testing.T) {
 	}
 }
 
+func TestMatchPatterns(t *testing.T) {
+	type row struct {
+		ChangeAtPath string
+		Match        []string
+		Exclude      []string
+		ShouldRun    bool
+	}
+
+	base := "/proj/"
+	table := []row{
+		{base + "main.go", nil, nil, true},
+		{base + "main.go", []string{"*.go"}, nil, true},
+		{base + "sub/pkg/main.go", []string{"*.go"}, nil, true},
+		{base + "README.md", []string{"*.go"}, nil, false},
+		{base + "templates/index.html", []string{"*.go", "templates/**"}, nil, true},
+		{base + "templates/partials/nav.html", []string{"templates/**"}, nil, true},
+		{base + "sub/templates/index.html", []string{"templates/**"}, nil, false},
+		{base + "bin/main.go", []string{"*.go"}, []string{"bin"}, false},
+	}
+
+	for _, row := range table {
+		act, err := DecideAction(
+			fsnotify.Event{Name: row.ChangeAtPath, Op: fsnotify.Write},
+			base,
+			row.Exclude,
+			nil,
+			row.Match,
+			nil,
+			disk,
+		)
+
+		check.OKWithMessage(t, err, "for path %s", row.ChangeAtPath)
+		check.AssertWithMessage(t, (act == ActionRun) == row.ShouldRun, "for path %s and match %v", row.ChangeAtPath, row.Match)
+	}
+}
+
+func TestDecideActionReportsRule(t *testing.T) {
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, ".warignore"), []byte("# temp files\n*.tmp\n"), 0666)
+	check.OK(t, err)
+
+	ignorer, err := newWarIgnore(dir, "")
+	check.OK(t, err)
+
+	type row struct {
+		ChangeAtPath string
+		Reason       string
+		Rule         string
+	}
+
+	table := []row{
+		{"sub/x.log", ReasonExcluded, "--exclude: *.log"},
+		{"sub/x.tmp", ReasonIgnoreFile, ".warignore: *.tmp"},
+		{"README.md", ReasonNotMatching, ""},
+	}
+
+	for _, row := range table {
+		act, reason, rule, err := decideAction(
+			fsnotify.Event{Name: filepath.Join(dir, row.ChangeAtPath), Op: fsnotify.Write},
+			dir,
+			[]string{"*.log"},
+			nil,
+			[]string{"*.go"},
+			ignorer,
+			disk,
+		)
+
+		check.OKWithMessage(t, err, "for path %s", row.ChangeAtPath)
+		check.Equals(t, ActionIgnore, act)
+		check.EqualsWithMessage(t, row.Reason, reason, "for path %s", row.ChangeAtPath)
+		check.EqualsWithMessage(t, row.Rule, rule, "for path %s", row.ChangeAtPath)
+	}
+}
+
 func TestIsDir(t *testing.T) {
 	var disk fs.FS
 
@@ -151,7 +244,7 @@ func TestIsDir(t *testing.T) {
 	}
 
 	for _, row := range table {
-		res, err := DecideAction(fsnotify.Event{Name: row.Path, Op: row.Op}, "/", nil, nil, disk)
+		res, err := DecideAction(fsnotify.Event{Name: row.Path, Op: row.Op}, "/", nil, nil, nil, nil, disk)
 		check.OK(t, err)
 		check.AssertWithMessage(t, (ActionAdd == res) == row.ShouldAdd, "for path %s and op %v", row.Path, row.Op)
 	}
@@ -172,7 +265,7 @@ type mockFS struct {
 func (m mockFS) Open(path string) (fs.File, error) {
 	file, ok := m.files[path]
 	if !ok {
-		return nil, fmt.Errorf("file '%s' not found", path)
+		return nil, &fs.PathError{Op: "open", Path: path, Err: fs.ErrNotExist}
 	}
 
 	return file, nil
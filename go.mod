@@ -1,13 +1,14 @@
 module github.com/doctordesh/war
 
-go 1.18
+go 1.21
 
 require (
+	github.com/BurntSushi/toml v1.4.0
+	github.com/bmatcuk/doublestar/v4 v4.6.1
+	github.com/doctordesh/check v0.0.0-20240207065046-eba349000778
 	github.com/fsnotify/fsnotify v1.4.9
 	github.com/logrusorgru/aurora v0.0.0-20200102142835-e9ef32dff381
+	gopkg.in/yaml.v3 v3.0.1
 )
 
-require (
-	github.com/doctordesh/check v0.0.0-20240207065046-eba349000778 // indirect
-	golang.org/x/sys v0.0.0-20220919091848-fb04ddd9f9c8 // indirect
-)
+require golang.org/x/sys v0.0.0-20220919091848-fb04ddd9f9c8
@@ -0,0 +1,207 @@
+package war
+
+import (
+	"strings"
+)
+
+// Hooks are called on the events in war's lifecycle. They are called from
+// war's goroutines, so they must be safe for concurrent use and should return
+// quickly. Embed NopHooks to only implement some of them.
+//
+// The name passed to the hooks is the name of the command, empty if it has
+// none.
+type Hooks interface {
+	// OnFileChanged is called for each change to a file that any command
+	// runs on, as it happens. The op is the kind of change, like WRITE.
+	OnFileChanged(path string, op string)
+
+	// OnChange is called with the files that changed within the debounce
+	// period, when it's over
+	OnChange(name string, paths []string)
+
+	// OnStart is called when a command is started
+	OnStart(name string, args []string, pid int)
+
+	// OnExit is called when a started command exits, by itself, timing out
+	// or stopped by war, see RunReport.Stopped
+	OnExit(report RunReport)
+
+	// OnIgnored is called with changes that don't trigger a run, and why,
+	// see the Reason constants. The rule is the exclude or ignore file rule
+	// that ignores it with where it's from, like 'sub/.gitignore: *.log', or
+	// empty if it's not ignored by a rule.
+	OnIgnored(path string, reason string, rule string)
+
+	// OnDirectoryAdded is called when a new directory is watched
+	OnDirectoryAdded(dir string)
+
+	// OnError is called when watching for changes or stopping a command
+	// fails, before Run returns the error
+	OnError(err error)
+}
+
+// NopHooks does nothing on every event
+type NopHooks struct{}
+
+func (NopHooks) OnFileChanged(path string, op string)              {}
+func (NopHooks) OnChange(name string, paths []string)              {}
+func (NopHooks) OnStart(name string, args []string, pid int)       {}
+func (NopHooks) OnExit(report RunReport)                           {}
+func (NopHooks) OnIgnored(path string, reason string, rule string) {}
+func (NopHooks) OnDirectoryAdded(dir string)                       {}
+func (NopHooks) OnError(err error)                                 {}
+
+// LogHooks prints the events with the Logger. It's the default hook, printing
+// with War.Logger.
+type LogHooks struct {
+	Logger Logger
+}
+
+func (h LogHooks) OnFileChanged(path string, op string) {}
+
+func (h LogHooks) OnChange(name string, paths []string) {
+	for _, p := range paths {
+		h.Logger.Infof(prefix(name, "file changed: %s"), p)
+	}
+
+	if len(paths) > 1 {
+		h.Logger.Infof(prefix(name, "%d files changed"), len(paths))
+	}
+}
+
+func (h LogHooks) OnStart(name string, args []string, pid int) {
+	h.Logger.Infof(prefix(name, "running command: %s"), strings.Join(args, " "))
+}
+
+// OnExit prints nothing for stopped commands, restarts are printed as they
+// happen
+func (h LogHooks) OnExit(report RunReport) {
+	if report.Stopped {
+		return
+	}
+
+	took := formatDuration(report.Duration())
+
+	if report.TimedOut {
+		h.Logger.Errorf(prefix(report.Name, "command timed out after %s%s"), took, report.sinceChange())
+	} else if report.ExitCode < 0 {
+		h.Logger.Errorf(prefix(report.Name, "command killed after %s%s"), took, report.sinceChange())
+	} else if report.ExitCode > 0 {
+		h.Logger.Errorf(prefix(report.Name, "command exited with code %d in %s%s"), report.ExitCode, took, report.sinceChange())
+	} else {
+		h.Logger.Successf(prefix(report.Name, "command succeeded in %s%s"), took, report.sinceChange())
+	}
+}
+
+func (h LogHooks) OnIgnored(path string, reason string, rule string) {}
+
+func (h LogHooks) OnDirectoryAdded(dir string) {
+	h.Logger.Infof("new directory detected %s", dir)
+}
+
+// OnError prints nothing, the error is returned by Run
+func (h LogHooks) OnError(err error) {}
+
+// EventLogger is a Logger that also logs structured events, like
+// colors.JSONLogger
+type EventLogger interface {
+	Logger
+	Event(event string, fields map[string]interface{})
+}
+
+// EventHooks logs the events as structured events
+type EventHooks struct {
+	Logger EventLogger
+}
+
+func (h EventHooks) OnFileChanged(path string, op string) {
+	h.Logger.Event("file_changed", map[string]interface{}{"path": path, "op": op})
+}
+
+func (h EventHooks) OnChange(name string, paths []string) {
+	h.Logger.Event("change", map[string]interface{}{"name": name, "paths": paths})
+}
+
+func (h EventHooks) OnStart(name string, args []string, pid int) {
+	h.Logger.Event("run_started", map[string]interface{}{"name": name, "argv": args, "pid": pid})
+}
+
+func (h EventHooks) OnExit(report RunReport) {
+	h.Logger.Event("run_finished", map[string]interface{}{
+		"name":            report.Name,
+		"exit_code":       report.ExitCode,
+		"signal":          report.Signal,
+		"timed_out":       report.TimedOut,
+		"stopped":         report.Stopped,
+		"duration_ms":     report.Duration().Milliseconds(),
+		"since_change_ms": report.SinceChange().Milliseconds(),
+		"files":           report.Files,
+	})
+}
+
+func (h EventHooks) OnIgnored(path string, reason string, rule string) {
+	h.Logger.Event("ignored", map[string]interface{}{"path": path, "reason": reason, "rule": rule})
+}
+
+func (h EventHooks) OnDirectoryAdded(dir string) {
+	h.Logger.Event("directory_added", map[string]interface{}{"path": dir})
+}
+
+func (h EventHooks) OnError(err error) {
+	h.Logger.Event("error", map[string]interface{}{"message": err.Error()})
+}
+
+// hooks calls all of the hooks, in order
+type hooks []Hooks
+
+func (h hooks) OnFileChanged(path string, op string) {
+	for _, hook := range h {
+		hook.OnFileChanged(path, op)
+	}
+}
+
+func (h hooks) OnChange(name string, paths []string) {
+	for _, hook := range h {
+		hook.OnChange(name, paths)
+	}
+}
+
+func (h hooks) OnStart(name string, args []string, pid int) {
+	for _, hook := range h {
+		hook.OnStart(name, args, pid)
+	}
+}
+
+func (h hooks) OnExit(report RunReport) {
+	for _, hook := range h {
+		hook.OnExit(report)
+	}
+}
+
+func (h hooks) OnIgnored(path string, reason string, rule string) {
+	for _, hook := range h {
+		hook.OnIgnored(path, reason, rule)
+	}
+}
+
+func (h hooks) OnDirectoryAdded(dir string) {
+	for _, hook := range h {
+		hook.OnDirectoryAdded(dir)
+	}
+}
+
+func (h hooks) OnError(err error) {
+	for _, hook := range h {
+		hook.OnError(err)
+	}
+}
+
+// prefix prefixes the message format with the name of the command, if it has
+// one
+func prefix(name string, format string) string {
+	if name == "" {
+		return format
+	}
+
+	return strings.ReplaceAll(name, "%", "%%") + ": " + format
+}
@@ -0,0 +1,80 @@
+package war
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/doctordesh/check"
+	"github.com/doctordesh/war/colors"
+)
+
+func TestEventHooks(t *testing.T) {
+	buf := &bytes.Buffer{}
+	h := EventHooks{Logger: colors.NewJSONLogger(buf)}
+
+	started := time.Now()
+	h.OnStart("test", []string{"go", "test"}, 42)
+	h.OnExit(RunReport{
+		Name:      "test",
+		StartedAt: started,
+		ExitedAt:  started.Add(time.Millisecond * 1500),
+		ExitCode:  -1,
+		Signal:    "SIGKILL",
+	})
+	h.OnIgnored("/base/x.tmp", ReasonNotMatching, "")
+	h.OnIgnored("/base/x.log", ReasonExcluded, "--exclude: *.log")
+
+	type event struct {
+		Event    string   `json:"event"`
+		Name     string   `json:"name"`
+		Argv     []string `json:"argv"`
+		Pid      int      `json:"pid"`
+		ExitCode int      `json:"exit_code"`
+		Signal   string   `json:"signal"`
+		Duration int      `json:"duration_ms"`
+		Path     string   `json:"path"`
+		Reason   string   `json:"reason"`
+		Rule     string   `json:"rule"`
+	}
+
+	events := []event{}
+	dec := json.NewDecoder(buf)
+	for dec.More() {
+		e := event{}
+		err := dec.Decode(&e)
+		check.OK(t, err)
+		events = append(events, e)
+	}
+
+	check.Equals(t, []event{
+		{Event: "run_started", Name: "test", Argv: []string{"go", "test"}, Pid: 42},
+		{Event: "run_finished", Name: "test", ExitCode: -1, Signal: "SIGKILL", Duration: 1500},
+		{Event: "ignored", Path: "/base/x.tmp", Reason: ReasonNotMatching},
+		{Event: "ignored", Path: "/base/x.log", Reason: ReasonExcluded, Rule: "--exclude: *.log"},
+	}, events)
+}
+
+func TestLogHooks(t *testing.T) {
+	buf := &bytes.Buffer{}
+	h := LogHooks{Logger: colors.NewLogger(buf, false)}
+
+	started := time.Now()
+	h.OnChange("test", []string{"/base/a.go", "/base/b.go"})
+	h.OnStart("test", []string{"go", "test"}, 42)
+	h.OnExit(RunReport{Name: "test", ExitCode: 1, StartedAt: started, ExitedAt: started.Add(time.Millisecond * 1500)})
+	h.OnExit(RunReport{Name: "test", Stopped: true, StartedAt: started, ExitedAt: started.Add(time.Second)})
+	h.OnDirectoryAdded("/base/new")
+
+	check.Equals(t, strings.Join([]string{
+		"[WAR] test: file changed: /base/a.go",
+		"[WAR] test: file changed: /base/b.go",
+		"[WAR] test: 2 files changed",
+		"[WAR] test: running command: go test",
+		"[WAR] test: command exited with code 1 in 1.5s",
+		"[WAR] new directory detected /base/new",
+		"",
+	}, "\n"), buf.String())
+}
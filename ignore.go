@@ -0,0 +1,393 @@
+package war
+
+import (
+	"bufio"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/bmatcuk/doublestar/v4"
+)
+
+// Ignorer decides if a path, relative to the watched directory, should be
+// ignored. Changed is called with every changed path so that implementations
+// backed by files on disk can reload them.
+type Ignorer interface {
+	Ignore(path string, isDir bool) bool
+	Changed(path string)
+}
+
+// ruleIgnorer is an Ignorer that can tell which rule ignores a path
+type ruleIgnorer interface {
+	// ignoredBy returns the rule that ignores the path, nil if it's not
+	// ignored
+	ignoredBy(path string, isDir bool) *ignoreRule
+}
+
+// ignoreRule is a single line from a .gitignore style file
+type ignoreRule struct {
+	pattern  string
+	negate   bool
+	dirOnly  bool
+	anchored bool
+
+	// text is the line as written, source is where it's from, like
+	// 'sub/.gitignore' or '--exclude'
+	text   string
+	source string
+}
+
+// String returns the rule and where it's from, like 'sub/.gitignore: *.log'
+func (r ignoreRule) String() string {
+	return r.source + ": " + r.text
+}
+
+// parseIgnoreRule parses a line from a .gitignore style file. Returns false if
+// the line is empty or a comment.
+func parseIgnoreRule(line string) (ignoreRule, bool) {
+	r := ignoreRule{}
+
+	line = strings.TrimRight(line, "\r")
+
+	// Trailing spaces are ignored unless escaped
+	for strings.HasSuffix(line, " ") && strings.HasSuffix(line, `\ `) == false {
+		line = strings.TrimSuffix(line, " ")
+	}
+
+	if line == "" || strings.HasPrefix(line, "#") {
+		return r, false
+	}
+
+	r.text = line
+
+	if strings.HasPrefix(line, "!") {
+		r.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\!`) || strings.HasPrefix(line, `\#`) {
+		line = line[1:]
+	}
+
+	if strings.HasSuffix(line, "/") {
+		r.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+
+	// A slash anywhere but at the end anchors the pattern to the directory of
+	// the file it was found in
+	if strings.Contains(line, "/") {
+		r.anchored = true
+		line = strings.TrimPrefix(line, "/")
+	}
+
+	if line == "" {
+		return r, false
+	}
+
+	r.pattern = line
+
+	return r, true
+}
+
+// match returns true if the rule matches the path, which must be relative to
+// the directory the rule was defined in.
+func (r ignoreRule) match(p string, isDir bool) bool {
+	if r.dirOnly && isDir == false {
+		return false
+	}
+
+	name := p
+	if r.anchored == false {
+		name = path.Base(p)
+	}
+
+	ok, _ := doublestar.Match(r.pattern, name)
+	return ok
+}
+
+type ignoreRules []ignoreRule
+
+// parseIgnoreRules parses all lines from a .gitignore style file, source is
+// the file's name
+func parseIgnoreRules(s string, source string) ignoreRules {
+	rules := ignoreRules{}
+
+	scanner := bufio.NewScanner(strings.NewReader(s))
+	for scanner.Scan() {
+		r, ok := parseIgnoreRule(scanner.Text())
+		if ok {
+			r.source = source
+			rules = append(rules, r)
+		}
+	}
+
+	return rules
+}
+
+// decide returns if the path is ignored by the rules, and the rule that
+// decided it. The last matching rule wins, as in git. The rule is nil if none
+// matched.
+func (rules ignoreRules) decide(p string, isDir bool) (bool, *ignoreRule) {
+	var matched *ignoreRule
+	for i, r := range rules {
+		if r.match(p, isDir) {
+			matched = &rules[i]
+		}
+	}
+
+	return matched != nil && matched.negate == false, matched
+}
+
+// ignores returns true if the path, or any of its parent directories, is
+// ignored by the rules, and the rule that ignores it
+func (rules ignoreRules) ignores(p string, isDir bool) (bool, *ignoreRule) {
+	if len(rules) == 0 {
+		return false, nil
+	}
+
+	p = path.Clean(strings.TrimPrefix(filepath.ToSlash(p), "/"))
+	if p == "." {
+		return false, nil
+	}
+
+	parts := strings.Split(p, "/")
+	for i := range parts {
+		sub := strings.Join(parts[:i+1], "/")
+		if ignored, rule := rules.decide(sub, i < len(parts)-1 || isDir); ignored {
+			return true, rule
+		}
+	}
+
+	return false, nil
+}
+
+// excludeRules turns the paths given with --exclude into rules. As in ignore
+// files and match patterns, 'bin' excludes every 'bin' directory but not
+// 'binary_test.go', and '/bin' only the top level one.
+func excludeRules(excludedPaths []string) ignoreRules {
+	rules := ignoreRules{}
+
+	for _, e := range excludedPaths {
+		r, ok := parseIgnoreRule(e)
+		if ok {
+			r.source = "--exclude"
+			rules = append(rules, r)
+		}
+	}
+
+	return rules
+}
+
+// ignorers is an Ignorer that ignores a path if any of its Ignorers does
+type ignorers []Ignorer
+
+func (l ignorers) Ignore(p string, isDir bool) bool {
+	for _, i := range l {
+		if i.Ignore(p, isDir) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (l ignorers) ignoredBy(p string, isDir bool) *ignoreRule {
+	for _, i := range l {
+		if r, ok := i.(ruleIgnorer); ok {
+			if rule := r.ignoredBy(p, isDir); rule != nil {
+				return rule
+			}
+		}
+	}
+
+	return nil
+}
+
+func (l ignorers) Changed(p string) {
+	for _, i := range l {
+		i.Changed(p)
+	}
+}
+
+// warIgnore is an Ignorer that honors the .warignore file in the watched
+// directory, and optionally an extra ignore file given with --ignore-file. The
+// extra file's rules are added after the .warignore rules, so they take
+// precedence. Both files use .gitignore syntax with doublestar globs, relative
+// to the watched directory.
+type warIgnore struct {
+	directory  string
+	ignoreFile string
+
+	mu    sync.Mutex
+	rules ignoreRules
+}
+
+func newWarIgnore(directory, ignoreFile string) (*warIgnore, error) {
+	var err error
+
+	if ignoreFile != "" {
+		ignoreFile, err = filepath.Abs(ignoreFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not find absolute path of ignore file '%s': %w", ignoreFile, err)
+		}
+	}
+
+	w := &warIgnore{directory: directory, ignoreFile: ignoreFile}
+
+	err = w.load()
+	if err != nil {
+		return nil, err
+	}
+
+	return w, nil
+}
+
+func (w *warIgnore) Ignore(p string, isDir bool) bool {
+	return w.ignoredBy(p, isDir) != nil
+}
+
+func (w *warIgnore) ignoredBy(p string, isDir bool) *ignoreRule {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	_, rule := w.rules.ignores(p, isDir)
+	return rule
+}
+
+// Changed reloads the rules if one of the ignore files has changed. If the
+// files can't be read the previous rules are kept.
+func (w *warIgnore) Changed(p string) {
+	abs := filepath.Join(w.directory, p)
+	if abs != filepath.Join(w.directory, ".warignore") && abs != w.ignoreFile {
+		return
+	}
+
+	_ = w.load()
+}
+
+func (w *warIgnore) load() error {
+	rules := ignoreRules{}
+
+	b, err := os.ReadFile(filepath.Join(w.directory, ".warignore"))
+	if err != nil && os.IsNotExist(err) == false {
+		return fmt.Errorf("could not read .warignore: %w", err)
+	}
+
+	rules = append(rules, parseIgnoreRules(string(b), ".warignore")...)
+
+	if w.ignoreFile != "" {
+		b, err = os.ReadFile(w.ignoreFile)
+		if err != nil {
+			return fmt.Errorf("could not read ignore file: %w", err)
+		}
+
+		rules = append(rules, parseIgnoreRules(string(b), w.ignoreFile)...)
+	}
+
+	w.mu.Lock()
+	w.rules = rules
+	w.mu.Unlock()
+
+	return nil
+}
+
+// gitIgnore is an Ignorer that honors the .gitignore file in the watched
+// directory and all .gitignore files in its sub directories. The files are
+// read lazily and cached until they change.
+type gitIgnore struct {
+	disk fs.FS
+
+	mu    sync.Mutex
+	rules map[string]ignoreRules
+}
+
+func newGitIgnore(disk fs.FS) *gitIgnore {
+	return &gitIgnore{disk: disk, rules: map[string]ignoreRules{}}
+}
+
+// Ignore returns true if the path, or any of its parent directories, is
+// ignored. Git never looks inside an ignored directory, so a negated pattern
+// cannot re-include a file whose parent is ignored.
+func (g *gitIgnore) Ignore(p string, isDir bool) bool {
+	return g.ignoredBy(p, isDir) != nil
+}
+
+func (g *gitIgnore) ignoredBy(p string, isDir bool) *ignoreRule {
+	p = path.Clean(strings.TrimPrefix(filepath.ToSlash(p), "/"))
+	if p == "." {
+		return nil
+	}
+
+	parts := strings.Split(p, "/")
+	for i := range parts {
+		sub := strings.Join(parts[:i+1], "/")
+		if ignored, rule := g.ignored(sub, i < len(parts)-1 || isDir); ignored {
+			return rule
+		}
+	}
+
+	return nil
+}
+
+// Changed drops the cached rules when a .gitignore file has changed
+func (g *gitIgnore) Changed(p string) {
+	p = path.Clean(filepath.ToSlash(p))
+	if path.Base(p) != ".gitignore" {
+		return
+	}
+
+	g.mu.Lock()
+	delete(g.rules, path.Dir(p))
+	g.mu.Unlock()
+}
+
+// ignored checks the path against the .gitignore files of all its parent
+// directories, where files further down take precedence. Returns the rule that
+// decided it, nil if none matched.
+func (g *gitIgnore) ignored(p string, isDir bool) (bool, *ignoreRule) {
+	ignored := false
+	var decided *ignoreRule
+
+	dir := "."
+	rel := p
+	for {
+		if ign, rule := g.load(dir).decide(rel, isDir); rule != nil {
+			ignored = ign
+			decided = rule
+		}
+
+		i := strings.Index(rel, "/")
+		if i < 0 {
+			break
+		}
+
+		dir = path.Join(dir, rel[:i])
+		rel = rel[i+1:]
+	}
+
+	return ignored, decided
+}
+
+// load returns the rules of the .gitignore file in dir, reading it from disk
+// if it's not cached already
+func (g *gitIgnore) load(dir string) ignoreRules {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	rules, ok := g.rules[dir]
+	if ok {
+		return rules
+	}
+
+	b, err := fs.ReadFile(g.disk, path.Join(dir, ".gitignore"))
+	if err == nil {
+		rules = parseIgnoreRules(string(b), path.Join(dir, ".gitignore"))
+	}
+
+	g.rules[dir] = rules
+
+	return rules
+}
@@ -0,0 +1,178 @@
+package war
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/doctordesh/check"
+)
+
+func TestParseIgnoreRule(t *testing.T) {
+	type row struct {
+		Line  string
+		Rule  ignoreRule
+		Valid bool
+	}
+
+	table := []row{
+		{"", ignoreRule{}, false},
+		{"# comment", ignoreRule{}, false},
+		{"*.log", ignoreRule{pattern: "*.log", text: "*.log"}, true},
+		{"*.log   ", ignoreRule{pattern: "*.log", text: "*.log"}, true},
+		{"!keep.log", ignoreRule{pattern: "keep.log", negate: true, text: "!keep.log"}, true},
+		{`\!bang`, ignoreRule{pattern: "!bang", text: `\!bang`}, true},
+		{`\#hash`, ignoreRule{pattern: "#hash", text: `\#hash`}, true},
+		{"build/", ignoreRule{pattern: "build", dirOnly: true, text: "build/"}, true},
+		{"/bin", ignoreRule{pattern: "bin", anchored: true, text: "/bin"}, true},
+		{"docs/*.md", ignoreRule{pattern: "docs/*.md", anchored: true, text: "docs/*.md"}, true},
+		{"**/gen/", ignoreRule{pattern: "**/gen", dirOnly: true, anchored: true, text: "**/gen/"}, true},
+	}
+
+	for _, row := range table {
+		rule, ok := parseIgnoreRule(row.Line)
+		check.EqualsWithMessage(t, row.Valid, ok, "for line %q", row.Line)
+		check.EqualsWithMessage(t, row.Rule, rule, "for line %q", row.Line)
+	}
+}
+
+func TestGitIgnore(t *testing.T) {
+	disk := fstest.MapFS{
+		".gitignore": {Data: []byte(
+			"# root ignores\n" +
+				"*.log\n" +
+				"!keep.log\n" +
+				"/bin\n" +
+				"build/\n" +
+				"**/gen/**/*.go\n" +
+				"node_modules/\n",
+		)},
+		"sub/.gitignore": {Data: []byte(
+			"*.tmp\n" +
+				"!important.log\n",
+		)},
+	}
+
+	type row struct {
+		Path         string
+		IsDir        bool
+		ShouldIgnore bool
+	}
+
+	table := []row{
+		{"main.go", false, false},
+		{"debug.log", false, true},
+		{"keep.log", false, false},
+		{"sub/debug.log", false, true},
+		{"bin", true, true},
+		{"bin/war", false, true},
+		{"sub/bin", true, false},
+		{"build", true, true},
+		{"build", false, false},
+		{"sub/build/out.o", false, true},
+		{"gen/a.go", false, true},
+		{"pkg/gen/deep/a.go", false, true},
+		{"pkg/gen/a.txt", false, false},
+		{"node_modules/pkg/index.js", false, true},
+		{"sub/x.tmp", false, true},
+		{"x.tmp", false, false},
+		{"sub/important.log", false, false},
+		{"sub/deeper/x.tmp", false, true},
+	}
+
+	g := newGitIgnore(disk)
+	for _, row := range table {
+		check.AssertWithMessage(t, g.Ignore(row.Path, row.IsDir) == row.ShouldIgnore, "for path %s (dir: %v)", row.Path, row.IsDir)
+	}
+
+	// The rule further down decides, and is reported with its file
+	check.Equals(t, "sub/.gitignore: *.tmp", g.ignoredBy("sub/deeper/x.tmp", false).String())
+	check.Equals(t, ".gitignore: *.log", g.ignoredBy("sub/debug.log", false).String())
+	check.Equals(t, ".gitignore: node_modules/", g.ignoredBy("node_modules/pkg/index.js", false).String())
+	check.Assert(t, g.ignoredBy("sub/important.log", false) == nil)
+}
+
+func TestGitIgnoreReloadsOnChange(t *testing.T) {
+	disk := fstest.MapFS{
+		"sub/.gitignore": {Data: []byte("*.tmp\n")},
+	}
+
+	g := newGitIgnore(disk)
+	check.Assert(t, g.Ignore("sub/x.tmp", false))
+
+	disk["sub/.gitignore"] = &fstest.MapFile{Data: []byte("*.bak\n")}
+	check.Assert(t, g.Ignore("sub/x.tmp", false))
+
+	g.Changed("sub/.gitignore")
+	check.Assert(t, g.Ignore("sub/x.tmp", false) == false)
+	check.Assert(t, g.Ignore("sub/x.bak", false))
+}
+
+func TestWarIgnore(t *testing.T) {
+	var err error
+
+	dir := t.TempDir()
+	extra := filepath.Join(t.TempDir(), "extra-ignore")
+
+	err = os.WriteFile(filepath.Join(dir, ".warignore"), []byte(
+		"*.log\n"+
+			"/bin\n"+
+			"docs/**/*.md\n"+
+			"{vendor,third_party}/\n"+
+			"gen/*\n"+
+			"!gen/keep.go\n",
+	), 0666)
+	check.OK(t, err)
+
+	err = os.WriteFile(extra, []byte("!important.log\n"), 0666)
+	check.OK(t, err)
+
+	w, err := newWarIgnore(dir, extra)
+	check.OK(t, err)
+
+	type row struct {
+		Path         string
+		IsDir        bool
+		ShouldIgnore bool
+	}
+
+	table := []row{
+		{"main.go", false, false},
+		{"debug.log", false, true},
+		{"sub/debug.log", false, true},
+		{"important.log", false, false},
+		{"bin/war", false, true},
+		{"binary_test.go", false, false},
+		{"sub/bin/war", false, false},
+		{"docs/index.md", false, true},
+		{"docs/api/v1/index.md", false, true},
+		{"docs/api/v1/index.html", false, false},
+		{"vendor/pkg/a.go", false, true},
+		{"sub/third_party", true, true},
+		{"gen/a.go", false, true},
+		{"gen/keep.go", false, false},
+	}
+
+	for _, row := range table {
+		check.AssertWithMessage(t, w.Ignore(row.Path, row.IsDir) == row.ShouldIgnore, "for path %s (dir: %v)", row.Path, row.IsDir)
+	}
+
+	err = os.WriteFile(filepath.Join(dir, ".warignore"), []byte("*.go\n"), 0666)
+	check.OK(t, err)
+
+	w.Changed(".warignore")
+	check.Assert(t, w.Ignore("debug.log", false) == false)
+	check.Assert(t, w.Ignore("main.go", false))
+}
+
+func TestWarIgnoreMissingFiles(t *testing.T) {
+	dir := t.TempDir()
+
+	w, err := newWarIgnore(dir, "")
+	check.OK(t, err)
+	check.Assert(t, w.Ignore("main.go", false) == false)
+
+	_, err = newWarIgnore(dir, filepath.Join(dir, "does-not-exist"))
+	check.NotOK(t, err)
+}
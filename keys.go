@@ -0,0 +1,40 @@
+package war
+
+import (
+	"context"
+	"io"
+)
+
+// Keys for the interactive keyboard controls
+const (
+	keyRerun = 'r'
+	keyQuit  = 'q'
+	keyClear = 'c'
+	keyPause = 'p'
+	keyHelp  = 'h'
+)
+
+func printKeyHelp(log Logger) {
+	log.Infof("keys: [r] rerun  [p] pause/resume watching  [c] clear screen  [q] quit  [h] help")
+}
+
+// readKeys sends every byte read from r on keys, until reading fails or ctx is
+// done
+func readKeys(ctx context.Context, r io.Reader, keys chan<- byte) {
+	buf := make([]byte, 1)
+
+	for {
+		n, err := r.Read(buf)
+		if err != nil {
+			return
+		}
+
+		if n == 1 {
+			select {
+			case keys <- buf[0]:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
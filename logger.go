@@ -0,0 +1,38 @@
+package war
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+)
+
+// Logger prints war's messages. The default, colors.Default(), prints them in
+// color to stderr.
+type Logger interface {
+	Infof(format string, args ...interface{})
+	Successf(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+// SlogLogger logs war's messages with a slog.Logger. Successes are logged at
+// the info level, with success=true.
+type SlogLogger struct {
+	Logger *slog.Logger
+}
+
+func (l SlogLogger) Infof(format string, args ...interface{}) {
+	l.Logger.Info(fmt.Sprintf(format, args...))
+}
+
+func (l SlogLogger) Successf(format string, args ...interface{}) {
+	l.Logger.LogAttrs(context.Background(), slog.LevelInfo, fmt.Sprintf(format, args...), slog.Bool("success", true))
+}
+
+func (l SlogLogger) Warnf(format string, args ...interface{}) {
+	l.Logger.Warn(fmt.Sprintf(format, args...))
+}
+
+func (l SlogLogger) Errorf(format string, args ...interface{}) {
+	l.Logger.Error(fmt.Sprintf(format, args...))
+}
@@ -0,0 +1,34 @@
+package war
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/doctordesh/check"
+)
+
+func TestSlogLogger(t *testing.T) {
+	buf := &bytes.Buffer{}
+	log := SlogLogger{Logger: slog.New(slog.NewTextHandler(buf, &slog.HandlerOptions{
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return a
+		},
+	}))}
+
+	log.Infof("watching %s", "/base")
+	log.Successf("command succeeded in %s", "0.1s")
+	log.Warnf("restarting command")
+	log.Errorf("command exited with code %d", 3)
+
+	check.Equals(t, []string{
+		`level=INFO msg="watching /base"`,
+		`level=INFO msg="command succeeded in 0.1s" success=true`,
+		`level=WARN msg="restarting command"`,
+		`level=ERROR msg="command exited with code 3"`,
+	}, strings.Split(strings.TrimSpace(buf.String()), "\n"))
+}
@@ -38,7 +38,7 @@ func TestNotify(t *testing.T) {
 	check.Equals(t, fsnotify.Create, event.Op)
 	check.Equals(t, fooBar, event.Name)
 
-	act, err = DecideAction(event, basePath, nil, nil, os.DirFS(basePath))
+	act, err = DecideAction(event, basePath, nil, nil, nil, nil, os.DirFS(basePath))
 	check.OK(t, err)
 	check.Equals(t, ActionAdd, act)
 
@@ -0,0 +1,130 @@
+package war
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Placeholders in RunnableTemplate.Args that are replaced with the changed
+// files when the command is built. {file} and the placeholders derived from it
+// refer to the first changed file.
+const (
+	PlaceholderFile    = "{file}"
+	PlaceholderRelFile = "{relfile}"
+	PlaceholderDir     = "{dir}"
+	PlaceholderExt     = "{ext}"
+	PlaceholderFiles   = "{files}"
+)
+
+// expandArgs replaces the placeholders in args. An argument that is exactly
+// {files} expands into one argument per file, anywhere else the files are
+// joined by spaces. If quote is set the values are quoted for the shell.
+//
+// When there are no changed files, as on the first run, arguments with
+// placeholders are dropped, and in a command line for the shell the
+// placeholders are removed.
+func expandArgs(args []string, files []string, dir string, quote bool) []string {
+	if hasPlaceholder(args) == false {
+		return args
+	}
+
+	q := func(s string) string { return s }
+	if quote && len(files) > 0 {
+		q = shellQuote
+	}
+
+	file := ""
+	if len(files) > 0 {
+		file = files[0]
+	}
+
+	relFile := file
+	if rel, err := filepath.Rel(dir, file); err == nil && dir != "" && file != "" {
+		relFile = rel
+	}
+
+	quoted := make([]string, len(files))
+	for i, f := range files {
+		quoted[i] = q(f)
+	}
+
+	r := strings.NewReplacer(
+		PlaceholderFile, q(file),
+		PlaceholderRelFile, q(relFile),
+		PlaceholderDir, q(dirOf(file)),
+		PlaceholderExt, q(filepath.Ext(file)),
+		PlaceholderFiles, strings.Join(quoted, " "),
+	)
+
+	expanded := make([]string, 0, len(args))
+	for i, a := range args {
+		if a == PlaceholderFiles && quote == false {
+			expanded = append(expanded, files...)
+			continue
+		}
+
+		if len(files) == 0 && quote == false && i > 0 && hasPlaceholder([]string{a}) {
+			continue
+		}
+
+		expanded = append(expanded, r.Replace(a))
+	}
+
+	return expanded
+}
+
+// changedEnv returns the environment variables describing the changed files
+func changedEnv(files []string) []string {
+	file := ""
+	if len(files) > 0 {
+		file = files[0]
+	}
+
+	return []string{
+		"WAR_CHANGED_FILE=" + file,
+		"WAR_CHANGED_FILES=" + strings.Join(files, string(os.PathListSeparator)),
+	}
+}
+
+func hasPlaceholder(args []string) bool {
+	for _, a := range args {
+		for _, p := range []string{PlaceholderFile, PlaceholderRelFile, PlaceholderDir, PlaceholderExt, PlaceholderFiles} {
+			if strings.Contains(a, p) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func dirOf(file string) string {
+	if file == "" {
+		return ""
+	}
+
+	return filepath.Dir(file)
+}
+
+// shellQuote quotes s with single quotes unless it only contains characters
+// that are safe in a shell
+func shellQuote(s string) string {
+	if s == "" {
+		return "''"
+	}
+
+	safe := true
+	for _, c := range s {
+		if strings.ContainsRune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_./:=+,@%", c) == false {
+			safe = false
+			break
+		}
+	}
+
+	if safe {
+		return s
+	}
+
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
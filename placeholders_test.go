@@ -0,0 +1,47 @@
+package war
+
+import (
+	"testing"
+
+	"github.com/doctordesh/check"
+)
+
+func TestExpandArgs(t *testing.T) {
+	type row struct {
+		Args     []string
+		Files    []string
+		Quote    bool
+		Expected []string
+	}
+
+	dir := "/proj"
+	files := []string{"/proj/pkg/foo.go", "/proj/pkg/bar.go"}
+
+	table := []row{
+		{[]string{"go", "test", "./..."}, files, false, []string{"go", "test", "./..."}},
+		{[]string{"gofmt", "-l", "{file}"}, files, false, []string{"gofmt", "-l", "/proj/pkg/foo.go"}},
+		{[]string{"echo", "{relfile}", "{dir}", "{ext}"}, files, false, []string{"echo", "pkg/foo.go", "/proj/pkg", ".go"}},
+		{[]string{"go", "test", "./{relfile}"}, files, false, []string{"go", "test", "./pkg/foo.go"}},
+		{[]string{"gofmt", "-l", "{files}"}, files, false, []string{"gofmt", "-l", "/proj/pkg/foo.go", "/proj/pkg/bar.go"}},
+		{[]string{"echo", "changed: {files}"}, files, false, []string{"echo", "changed: /proj/pkg/foo.go /proj/pkg/bar.go"}},
+		{[]string{"gofmt", "-l", "{file}"}, nil, false, []string{"gofmt", "-l"}},
+		{[]string{"gofmt", "-l", "{files}"}, nil, false, []string{"gofmt", "-l"}},
+		{[]string{"go", "test", "./{relfile}"}, nil, false, []string{"go", "test"}},
+		{[]string{"echo", "changed: {files}"}, nil, false, []string{"echo"}},
+		{[]string{"gofmt -l {file}"}, nil, true, []string{"gofmt -l "}},
+		{[]string{"cat {files}"}, []string{"/proj/it's here.txt", "/proj/a.txt"}, true, []string{`cat '/proj/it'\''s here.txt' /proj/a.txt`}},
+	}
+
+	for _, row := range table {
+		res := expandArgs(row.Args, row.Files, dir, row.Quote)
+		check.EqualsWithMessage(t, row.Expected, res, "for args %v", row.Args)
+	}
+}
+
+func TestChangedEnv(t *testing.T) {
+	check.Equals(t, []string{"WAR_CHANGED_FILE=", "WAR_CHANGED_FILES="}, changedEnv(nil))
+	check.Equals(t,
+		[]string{"WAR_CHANGED_FILE=/a.go", "WAR_CHANGED_FILES=/a.go:/b.go"},
+		changedEnv([]string{"/a.go", "/b.go"}),
+	)
+}
@@ -0,0 +1,174 @@
+package war
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// pollBackend is a backend that detects changes by comparing the entries of
+// each added directory every interval. It's slower than inotify but works on
+// file systems where inotify events never arrive, like FUSE, 9p or virtiofs.
+type pollBackend struct {
+	interval time.Duration
+
+	events chan fsnotify.Event
+	errors chan error
+	done   chan struct{}
+
+	mu   sync.Mutex
+	dirs map[string]map[string]fileStat
+}
+
+// fileStat is what's compared between two polls to detect a change
+type fileStat struct {
+	modTime time.Time
+	size    int64
+	inode   uint64
+	isDir   bool
+}
+
+func newPollBackend(interval time.Duration) *pollBackend {
+	b := &pollBackend{
+		interval: interval,
+		events:   make(chan fsnotify.Event),
+		errors:   make(chan error),
+		done:     make(chan struct{}),
+		dirs:     map[string]map[string]fileStat{},
+	}
+
+	go b.loop()
+
+	return b
+}
+
+func (b *pollBackend) Add(dir string) error {
+	entries, err := scanDir(dir)
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	b.dirs[dir] = entries
+	b.mu.Unlock()
+
+	return nil
+}
+
+func (b *pollBackend) Events() <-chan fsnotify.Event { return b.events }
+func (b *pollBackend) Errors() <-chan error          { return b.errors }
+
+func (b *pollBackend) Close() error {
+	close(b.done)
+	return nil
+}
+
+func (b *pollBackend) loop() {
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-b.done:
+			return
+		case <-ticker.C:
+		}
+
+		b.mu.Lock()
+		dirs := make([]string, 0, len(b.dirs))
+		for dir := range b.dirs {
+			dirs = append(dirs, dir)
+		}
+		b.mu.Unlock()
+
+		for _, dir := range dirs {
+			for _, event := range b.poll(dir) {
+				select {
+				case b.events <- event:
+				case <-b.done:
+					return
+				}
+			}
+		}
+	}
+}
+
+// poll scans dir and returns the events since the last scan
+func (b *pollBackend) poll(dir string) []fsnotify.Event {
+	events := []fsnotify.Event{}
+
+	current, err := scanDir(dir)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	previous, ok := b.dirs[dir]
+	if !ok {
+		return events
+	}
+
+	// The directory itself is gone, stop watching it like inotify does
+	if err != nil {
+		delete(b.dirs, dir)
+		return events
+	}
+
+	for name, stat := range current {
+		path := filepath.Join(dir, name)
+
+		prev, ok := previous[name]
+		switch {
+		case !ok || prev.inode != stat.inode:
+			events = append(events, fsnotify.Event{Name: path, Op: fsnotify.Create})
+		case stat.isDir:
+			// Changes inside sub directories are reported by polling them
+		case prev.size != stat.size || prev.modTime.Equal(stat.modTime) == false:
+			events = append(events, fsnotify.Event{Name: path, Op: fsnotify.Write})
+		}
+	}
+
+	for name := range previous {
+		if _, ok := current[name]; !ok {
+			events = append(events, fsnotify.Event{Name: filepath.Join(dir, name), Op: fsnotify.Remove})
+		}
+	}
+
+	b.dirs[dir] = current
+
+	return events
+}
+
+// scanDir returns the stats of all entries in dir
+func scanDir(dir string) (map[string]fileStat, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := make(map[string]fileStat, len(entries))
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil {
+			// Removed since the directory was read
+			continue
+		}
+
+		stat := fileStat{
+			modTime: info.ModTime(),
+			size:    info.Size(),
+			isDir:   info.IsDir(),
+		}
+
+		if sys, ok := info.Sys().(*syscall.Stat_t); ok {
+			stat.inode = uint64(sys.Ino)
+		}
+
+		stats[e.Name()] = stat
+	}
+
+	return stats, nil
+}
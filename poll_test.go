@@ -0,0 +1,58 @@
+package war
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/doctordesh/check"
+	"github.com/fsnotify/fsnotify"
+)
+
+func TestPollBackend(t *testing.T) {
+	var err error
+
+	basePath := t.TempDir()
+	newFile := filepath.Join(basePath, "new-file")
+	newDir := filepath.Join(basePath, "new-dir")
+
+	b := newPollBackend(time.Millisecond * 10)
+	defer b.Close()
+
+	err = b.Add(basePath)
+	check.OK(t, err)
+
+	next := func() fsnotify.Event {
+		t.Helper()
+
+		select {
+		case event := <-b.Events():
+			return event
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for event")
+		}
+
+		return fsnotify.Event{}
+	}
+
+	t.Logf("Creates     %s", newFile)
+	err = os.WriteFile(newFile, []byte("lorem"), 0666)
+	check.OK(t, err)
+	check.Equals(t, fsnotify.Event{Name: newFile, Op: fsnotify.Create}, next())
+
+	t.Logf("Writes to   %s", newFile)
+	err = os.WriteFile(newFile, []byte("lorem ipsum"), 0666)
+	check.OK(t, err)
+	check.Equals(t, fsnotify.Event{Name: newFile, Op: fsnotify.Write}, next())
+
+	t.Logf("Removes     %s", newFile)
+	err = os.Remove(newFile)
+	check.OK(t, err)
+	check.Equals(t, fsnotify.Event{Name: newFile, Op: fsnotify.Remove}, next())
+
+	t.Logf("Creates     %s", newDir)
+	err = os.Mkdir(newDir, 0777)
+	check.OK(t, err)
+	check.Equals(t, fsnotify.Event{Name: newDir, Op: fsnotify.Create}, next())
+}
@@ -0,0 +1,171 @@
+package war
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// DefaultReadyTimeout is used when ReadyCheck.Timeout is zero
+const DefaultReadyTimeout = time.Second * 30
+
+// readyInterval is the time between checks of the TCP address or HTTP URL
+const readyInterval = time.Millisecond * 100
+
+var (
+	ErrNotReady          = errors.New("not ready")
+	ErrExitedBeforeReady = errors.New("exited before it was ready")
+)
+
+// ReadyCheck tells when a started command, like a server, is ready. All of
+// the set checks must pass.
+type ReadyCheck struct {
+	// TCP is an address, like localhost:8080, that accepts connections once
+	// the command is ready
+	TCP string
+
+	// HTTP is a URL that responds to GET with a 2xx status once the command
+	// is ready
+	HTTP string
+
+	// Output matches a line the command writes to stdout once it's ready
+	Output *regexp.Regexp
+
+	// Timeout is how long to wait for the command to be ready, see
+	// DefaultReadyTimeout
+	Timeout time.Duration
+}
+
+// waitReady waits for the command to pass the ready check. Returns how long it
+// took, ErrNotReady if it wasn't ready within the timeout, or
+// ErrExitedBeforeReady if the command exited before.
+func (self *runnable) waitReady(check ReadyCheck) (time.Duration, error) {
+	started := time.Now()
+
+	deadline := time.NewTimer(check.timeout())
+	defer deadline.Stop()
+
+	if self.outputMatched != nil {
+		select {
+		case <-self.outputMatched:
+		case <-self.done:
+			return 0, ErrExitedBeforeReady
+		case <-deadline.C:
+			return 0, ErrNotReady
+		}
+	}
+
+	ticker := time.NewTicker(readyInterval)
+	defer ticker.Stop()
+
+	for {
+		if checkTCP(check.TCP) && checkHTTP(check.HTTP) {
+			return time.Since(started), nil
+		}
+
+		select {
+		case <-ticker.C:
+		case <-self.done:
+			return 0, ErrExitedBeforeReady
+		case <-deadline.C:
+			return 0, ErrNotReady
+		}
+	}
+}
+
+func (check ReadyCheck) timeout() time.Duration {
+	if check.Timeout == 0 {
+		return DefaultReadyTimeout
+	}
+
+	return check.Timeout
+}
+
+// checkTCP returns true if the address accepts connections, or is empty
+func checkTCP(address string) bool {
+	if address == "" {
+		return true
+	}
+
+	conn, err := net.DialTimeout("tcp", address, readyInterval)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+
+	return true
+}
+
+// checkHTTP returns true if GET on the URL responds with a 2xx status, or if
+// the URL is empty
+func checkHTTP(url string) bool {
+	if url == "" {
+		return true
+	}
+
+	client := http.Client{Timeout: time.Second}
+
+	res, err := client.Get(url)
+	if err != nil {
+		return false
+	}
+	res.Body.Close()
+
+	return res.StatusCode >= 200 && res.StatusCode < 300
+}
+
+// maxLineLength is the longest line matchWriter keeps to match against, the
+// rest of a longer line is not matched
+const maxLineLength = 64 * 1024
+
+// matchWriter writes through to w, and closes matched the first time a line
+// matching re is written
+type matchWriter struct {
+	w       io.Writer
+	re      *regexp.Regexp
+	matched chan struct{}
+
+	mu   sync.Mutex
+	line []byte
+	once sync.Once
+}
+
+func newMatchWriter(w io.Writer, re *regexp.Regexp) *matchWriter {
+	return &matchWriter{w: w, re: re, matched: make(chan struct{})}
+}
+
+func (m *matchWriter) Write(p []byte) (int, error) {
+	m.match(p)
+
+	if m.w == nil {
+		return len(p), nil
+	}
+
+	return m.w.Write(p)
+}
+
+func (m *matchWriter) match(p []byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			m.line = append(m.line, p[:min(len(p), maxLineLength-len(m.line))]...)
+			return
+		}
+
+		m.line = append(m.line, p[:min(i, maxLineLength-len(m.line))]...)
+		if m.re.Match(m.line) {
+			m.once.Do(func() { close(m.matched) })
+		}
+
+		m.line = m.line[:0]
+		p = p[i+1:]
+	}
+}
@@ -0,0 +1,74 @@
+package war
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/doctordesh/check"
+)
+
+func TestReadyTCP(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	check.OK(t, err)
+	defer l.Close()
+
+	r := shellTemplate(t, `sleep 5`).Build(nil)
+	err = r.Start()
+	check.OK(t, err)
+	defer r.Stop()
+
+	_, err = r.waitReady(ReadyCheck{TCP: l.Addr().String()})
+	check.OK(t, err)
+}
+
+func TestReadyHTTP(t *testing.T) {
+	status := int32(http.StatusServiceUnavailable)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(int(atomic.LoadInt32(&status)))
+	}))
+	defer srv.Close()
+
+	r := shellTemplate(t, `sleep 5`).Build(nil)
+	err := r.Start()
+	check.OK(t, err)
+	defer r.Stop()
+
+	_, err = r.waitReady(ReadyCheck{HTTP: srv.URL, Timeout: time.Millisecond * 300})
+	check.Equals(t, ErrNotReady, err)
+
+	atomic.StoreInt32(&status, http.StatusOK)
+
+	_, err = r.waitReady(ReadyCheck{HTTP: srv.URL})
+	check.OK(t, err)
+}
+
+func TestReadyOutput(t *testing.T) {
+	ready := &ReadyCheck{Output: regexp.MustCompile(`listening on :\d+`)}
+
+	tpl := shellTemplate(t, `echo starting; sleep 0.1; printf "listening on :80"; echo 80; sleep 5`)
+	tpl.Ready = ready
+
+	r := tpl.Build(nil)
+	err := r.Start()
+	check.OK(t, err)
+	defer r.Stop()
+
+	took, err := r.waitReady(*ready)
+	check.OK(t, err)
+	check.Assert(t, took >= time.Millisecond*100)
+
+	tpl = shellTemplate(t, `echo starting`)
+	tpl.Ready = ready
+
+	r = tpl.Build(nil)
+	err = r.Start()
+	check.OK(t, err)
+
+	_, err = r.waitReady(*ready)
+	check.Equals(t, ErrExitedBeforeReady, err)
+}
@@ -0,0 +1,78 @@
+package war
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+)
+
+// RunReport describes a finished run of a command
+type RunReport struct {
+	// Name is the name of the command, if it has one
+	Name    string
+	Command string
+	Dir     string
+
+	// Files are the changed files that triggered the run, and ChangedAt is
+	// when the first of them changed. Both are empty for runs that were not
+	// triggered by changes, like the initial run.
+	Files     []string
+	ChangedAt time.Time
+
+	StartedAt time.Time
+	ExitedAt  time.Time
+
+	// ExitCode is the command's exit code, negative if it was killed by a
+	// signal. Signal is the name of the signal, like SIGKILL.
+	ExitCode int
+	Signal   string
+	TimedOut bool
+
+	// Stopped is set if war stopped the command, to restart it or because
+	// Run returned
+	Stopped bool
+}
+
+// Duration returns how long the command ran
+func (r RunReport) Duration() time.Duration {
+	return r.ExitedAt.Sub(r.StartedAt)
+}
+
+// SinceChange returns the time from the change that triggered the run until
+// the command was started, zero if it wasn't triggered by a change
+func (r RunReport) SinceChange() time.Duration {
+	if r.ChangedAt.IsZero() {
+		return 0
+	}
+
+	return r.StartedAt.Sub(r.ChangedAt)
+}
+
+// Succeeded returns true if the command exited with code 0 in time
+func (r RunReport) Succeeded() bool {
+	return r.ExitCode == 0 && r.TimedOut == false
+}
+
+// sinceChange describes the change that triggered the run for the output, like
+// ", 0.2s after change to foo.go". Empty if it wasn't triggered by a change.
+func (r RunReport) sinceChange() string {
+	if len(r.Files) == 0 || r.ChangedAt.IsZero() {
+		return ""
+	}
+
+	file := r.Files[0]
+	if rel, err := filepath.Rel(r.Dir, file); err == nil && r.Dir != "" {
+		file = rel
+	}
+
+	if len(r.Files) > 1 {
+		return fmt.Sprintf(", %s after changes to %s and %d more", formatDuration(r.SinceChange()), file, len(r.Files)-1)
+	}
+
+	return fmt.Sprintf(", %s after change to %s", formatDuration(r.SinceChange()), file)
+}
+
+// formatDuration formats the duration in seconds with one decimal, like 3.4s
+func formatDuration(d time.Duration) string {
+	return fmt.Sprintf("%.1fs", d.Seconds())
+}
@@ -0,0 +1,53 @@
+package war
+
+import (
+	"testing"
+	"time"
+
+	"github.com/doctordesh/check"
+)
+
+func TestRunReportSinceChange(t *testing.T) {
+	changed := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	type row struct {
+		Report   RunReport
+		Expected string
+	}
+
+	table := []row{
+		{RunReport{}, ""},
+		{RunReport{
+			Dir:       "/base",
+			Files:     []string{"/base/foo.go"},
+			ChangedAt: changed,
+			StartedAt: changed.Add(time.Millisecond * 200),
+		}, ", 0.2s after change to foo.go"},
+		{RunReport{
+			Dir:       "/base",
+			Files:     []string{"/base/cmd/main.go", "/base/foo.go", "/base/bar.go"},
+			ChangedAt: changed,
+			StartedAt: changed.Add(time.Millisecond * 1250),
+		}, ", 1.2s after changes to cmd/main.go and 2 more"},
+	}
+
+	for _, row := range table {
+		check.EqualsWithMessage(t, row.Expected, row.Report.sinceChange(), "for files %v", row.Report.Files)
+	}
+}
+
+func TestRunnableReport(t *testing.T) {
+	r := shellTemplate(t, `sleep 0.1; exit 3`).Build([]string{"/base/foo.go"})
+	r.changedAt = time.Now()
+
+	err := r.Start()
+	check.OK(t, err)
+	<-r.done
+
+	report := r.Report()
+	check.Equals(t, 3, report.ExitCode)
+	check.Equals(t, []string{"/base/foo.go"}, report.Files)
+	check.Equals(t, false, report.Succeeded())
+	check.Assert(t, report.Duration() >= time.Millisecond*100)
+	check.Assert(t, report.SinceChange() >= 0)
+}
@@ -1,33 +1,128 @@
 package war
 
 import (
+	"errors"
 	"fmt"
 	"io"
+	"os"
 	"os/exec"
+	"strings"
+	"sync"
 	"syscall"
+	"time"
+
+	"github.com/doctordesh/war/colors"
 )
 
+// exitCodeInterrupted is what shells and many commands exit with on SIGINT
+const exitCodeInterrupted = 128 + int(syscall.SIGINT)
+
+// DefaultStopTimeout is used when RunnableTemplate.StopTimeout is zero
+const DefaultStopTimeout = time.Second * 5
+
 type RunnableTemplate struct {
+	// Name identifies the command in the output when there are several
+	Name string
+
 	BinPath  string
 	Args     []string
 	Env      []string
 	Excludes []string
+	Matches  []string
 	Dir      string
-	Stdout   io.Writer
-	Stderr   io.Writer
+
+	// Stdin is given to the command. If it's the terminal, the command's
+	// process group is put in its foreground while it runs, and war's is put
+	// back when it exits.
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+
+	// Shell runs Args joined into a command line with '$SHELL -c', or
+	// '/bin/sh -c' if $SHELL is not set. BinPath is not used.
+	Shell bool
+
+	// StopSignal is the first signal sent to the process group when the
+	// command is stopped, SIGINT if zero. If the processes have not exited
+	// within StopTimeout it's followed by SIGTERM and then SIGKILL.
+	StopSignal  syscall.Signal
+	StopTimeout time.Duration
+
+	// Timeout stops the command the same way if it's still running after
+	// it, and marks it as timed out. Zero means no timeout.
+	Timeout time.Duration
+
+	// Ready, if set, is checked after the command is started, to report when
+	// it is ready. Useful for commands that don't exit, like servers.
+	Ready *ReadyCheck
+
+	// Steps are run in order before the command, each one waiting for the
+	// previous to exit. If a step fails the remaining steps and the command
+	// are not run. Only the command itself is stopped on the next change.
+	Steps []RunnableTemplate
 }
 
-func (self RunnableTemplate) Build() *runnable {
+// Build creates a runnable from the template, with the placeholders in Args
+// replaced by the changed files that triggered the run.
+func (self RunnableTemplate) Build(changed []string) *runnable {
 	cmd := &exec.Cmd{}
 	cmd.Path = self.BinPath
-	cmd.Args = self.Args
-	cmd.Env = append(cmd.Environ(), self.Env...)
+	cmd.Args = expandArgs(self.Args, changed, self.Dir, self.Shell)
+	if self.Shell {
+		cmd.Path = shellPath()
+		cmd.Args = []string{cmd.Path, "-c", strings.Join(cmd.Args, " ")}
+	}
+	cmd.Env = append(cmd.Environ(), changedEnv(changed)...)
+	cmd.Env = append(cmd.Env, self.Env...)
 	cmd.Dir = self.Dir
+	cmd.Stdin = self.Stdin
 	cmd.Stdout = self.Stdout
 	cmd.Stderr = self.Stderr
 	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
 
-	return &runnable{state: RunningStateNotStarted, cmd: cmd}
+	// Reading the terminal from a background process group stops the command
+	// with SIGTTIN
+	tty, isTTY := foregroundTerminal(self.Stdin)
+	if isTTY {
+		cmd.SysProcAttr.Foreground = true
+		cmd.SysProcAttr.Ctty = int(tty.Fd())
+	}
+
+	r := &runnable{
+		state:       RunningStateNotStarted,
+		cmd:         cmd,
+		stopSignal:  self.StopSignal,
+		stopTimeout: self.StopTimeout,
+		timeout:     self.Timeout,
+		files:       changed,
+		terminal:    tty,
+		done:        make(chan struct{}),
+	}
+
+	if self.Ready != nil && self.Ready.Output != nil {
+		m := newMatchWriter(cmd.Stdout, self.Ready.Output)
+		cmd.Stdout = m
+		r.outputMatched = m.matched
+	}
+
+	if r.stopSignal == 0 {
+		r.stopSignal = syscall.SIGINT
+	}
+
+	if r.stopTimeout == 0 {
+		r.stopTimeout = DefaultStopTimeout
+	}
+
+	return r
+}
+
+// shellPath returns the user's shell, or /bin/sh
+func shellPath() string {
+	if sh := os.Getenv("SHELL"); sh != "" {
+		return sh
+	}
+
+	return "/bin/sh"
 }
 
 type RunningState string
@@ -41,15 +136,54 @@ const (
 type runnable struct {
 	cmd *exec.Cmd
 
+	stopSignal  syscall.Signal
+	stopTimeout time.Duration
+
+	timeout  time.Duration
+	timedOut bool
+
+	// changedAt is when the change that triggered the run happened, files are
+	// the changed files. startedAt and exitedAt are set when the process
+	// starts and exits.
+	changedAt time.Time
+	files     []string
+	startedAt time.Time
+	exitedAt  time.Time
+
+	// done is closed when the process has exited
+	done chan struct{}
+
+	// outputMatched is closed when the output matches ReadyCheck.Output
+	outputMatched chan struct{}
+
+	// terminal is the terminal the command has while it runs, nil if none
+	terminal *os.File
+
+	// log prints the messages about stopping the command, colors.Default()
+	// if nil
+	log Logger
+
+	// mu guards the fields below, and timedOut, startedAt and exitedAt, which
+	// are written by the goroutines waiting for the process. stopping is set
+	// when war stops the command.
+	mu       sync.Mutex
 	state    RunningState
 	exitCode int
+	signal   syscall.Signal
+	stopping bool
 }
 
 func (self *runnable) State() RunningState {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
 	return self.state
 }
 
 func (self *runnable) Start() error {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
 	if self.state == RunningStateRunning {
 		return fmt.Errorf("already started")
 	}
@@ -63,30 +197,153 @@ func (self *runnable) Start() error {
 		return fmt.Errorf("could not start: %w", err)
 	}
 
+	self.startedAt = time.Now()
+	self.state = RunningStateRunning
+
 	go self.wait()
 
-	self.state = RunningStateRunning
+	if self.timeout > 0 {
+		go self.stopAfter(self.timeout)
+	}
 
 	return nil
 }
 
+// stopAfter stops the process group if it's still running after the timeout
+func (self *runnable) stopAfter(timeout time.Duration) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-self.done:
+		return
+	case <-timer.C:
+	}
+
+	// A command that exited just as the timer fired did not time out
+	self.mu.Lock()
+	if self.state != RunningStateRunning {
+		self.mu.Unlock()
+		return
+	}
+	self.timedOut = true
+	self.stopping = true
+	self.mu.Unlock()
+
+	err := self.stopGroup(self.cmd.Process.Pid)
+	if err != nil {
+		self.logger().Errorf("could not stop timed out command: %v", err)
+	}
+}
+
+// TimedOut returns true if the command was stopped because it ran for longer
+// than its timeout
+func (self *runnable) TimedOut() bool {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	return self.timedOut
+}
+
+// Stop sends the stop signal to the process group, escalating to SIGTERM and
+// SIGKILL if it has not exited within the stop timeout. It returns once all
+// processes in the group have exited. A command that was never started can't
+// be started after it's stopped.
 func (self *runnable) Stop() error {
-	if self.state == RunningStateRunning {
-		err := syscall.Kill(-self.cmd.Process.Pid, syscall.SIGINT)
+	self.mu.Lock()
+	state := self.state
+	if state == RunningStateNotStarted {
+		self.state = RunningStateStopped
+	}
+	self.stopping = true
+	self.mu.Unlock()
+
+	if state != RunningStateRunning {
+		return nil
+	}
+
+	err := self.stopGroup(self.cmd.Process.Pid)
+	if err != nil {
+		return err
+	}
+
+	<-self.done
+
+	return nil
+}
+
+func (self *runnable) stopGroup(pgid int) error {
+	// Escalate from the stop signal to SIGTERM and finally SIGKILL
+	signals := []syscall.Signal{self.stopSignal}
+	if self.stopSignal != syscall.SIGTERM && self.stopSignal != syscall.SIGKILL {
+		signals = append(signals, syscall.SIGTERM)
+	}
+	if self.stopSignal != syscall.SIGKILL {
+		signals = append(signals, syscall.SIGKILL)
+	}
+
+	for i, sig := range signals {
+		if i > 0 {
+			self.logger().Warnf("command did not stop within %s, sending %s", self.stopTimeout, signalName(sig))
+		}
+
+		err := syscall.Kill(-pgid, sig)
+		if errors.Is(err, syscall.ESRCH) {
+			return nil
+		}
+
 		if err != nil {
-			err = syscall.Kill(-self.cmd.Process.Pid, syscall.SIGKILL)
-			if err != nil {
-				return fmt.Errorf("could not kill process: %w", err)
-			}
+			return fmt.Errorf("could not send %s to process group: %w", signalName(sig), err)
+		}
+
+		// SIGKILL can't be ignored, so anything left in the group after it is
+		// a zombie waiting to be reaped by init
+		if self.waitGroup(pgid, self.stopTimeout, sig != syscall.SIGKILL) {
+			return nil
 		}
 	}
 
-	self.state = RunningStateStopped
+	return fmt.Errorf("process group %d did not exit after %s", pgid, signalName(syscall.SIGKILL))
+}
 
-	return nil
+// waitGroup waits for the process, and then if wholeGroup is set for the rest
+// of its process group, to exit. Returns false if they have not exited within
+// the timeout.
+func (self *runnable) waitGroup(pgid int, timeout time.Duration, wholeGroup bool) bool {
+	deadline := time.After(timeout)
+
+	select {
+	case <-self.done:
+	case <-deadline:
+		return false
+	}
+
+	if wholeGroup == false {
+		return true
+	}
+
+	ticker := time.NewTicker(time.Millisecond * 10)
+	defer ticker.Stop()
+
+	for {
+		// Signal 0 only checks if any process in the group is still alive
+		err := syscall.Kill(-pgid, 0)
+		if errors.Is(err, syscall.ESRCH) {
+			return true
+		}
+
+		select {
+		case <-ticker.C:
+		case <-deadline:
+			return false
+		}
+	}
 }
 
 func (self *runnable) ExitCode() (int, error) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
 	if self.state != RunningStateStopped {
 		return 0, fmt.Errorf("not done yet")
 	}
@@ -96,6 +353,11 @@ func (self *runnable) ExitCode() (int, error) {
 
 func (self *runnable) wait() {
 	err := self.cmd.Wait()
+
+	self.mu.Lock()
+	defer close(self.done)
+	defer self.mu.Unlock()
+
 	if err != nil {
 		if exiterr, ok := err.(*exec.ExitError); ok {
 			// if exiterr.ExitCode() == -1 {
@@ -104,10 +366,93 @@ func (self *runnable) wait() {
 			// 	log.Printf("Setting exit code: %+v\n", exiterr.ExitCode())
 			// }
 			self.exitCode = exiterr.ExitCode()
+
+			if status, ok := exiterr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+				self.signal = status.Signal()
+			}
 		} else {
-			panic(err)
+			self.logger().Errorf("could not wait for command: %v", err)
+			self.exitCode = -1
 		}
 	}
 
+	self.exitedAt = time.Now()
 	self.state = RunningStateStopped
+
+	if self.terminal != nil {
+		takeTerminal(self.terminal, self.cmd.Process.Pid)
+
+		// ctrl-c went to the command instead of war, so pass it on
+		interrupted := self.signal == syscall.SIGINT || self.exitCode == exitCodeInterrupted
+		if interrupted && self.stopping == false {
+			syscall.Kill(os.Getpid(), syscall.SIGINT)
+		}
+	}
+}
+
+// Report returns the report of the run, only complete once it has exited
+func (self *runnable) Report() RunReport {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	report := RunReport{
+		Command:   self.cmd.String(),
+		Files:     self.files,
+		ChangedAt: self.changedAt,
+		StartedAt: self.startedAt,
+		ExitedAt:  self.exitedAt,
+		ExitCode:  self.exitCode,
+		TimedOut:  self.timedOut,
+	}
+
+	if self.signal != 0 {
+		report.Signal = signalName(self.signal)
+	}
+
+	return report
+}
+
+func (self *runnable) logger() Logger {
+	if self.log == nil {
+		return colors.Default()
+	}
+
+	return self.log
+}
+
+// signalName returns the conventional name of the signal, like SIGINT
+func signalName(sig syscall.Signal) string {
+	for name, s := range signalNames {
+		if s == sig {
+			return name
+		}
+	}
+
+	return sig.String()
+}
+
+// signalNames are the signals that can be used to stop a command
+var signalNames = map[string]syscall.Signal{
+	"SIGHUP":  syscall.SIGHUP,
+	"SIGINT":  syscall.SIGINT,
+	"SIGQUIT": syscall.SIGQUIT,
+	"SIGKILL": syscall.SIGKILL,
+	"SIGUSR1": syscall.SIGUSR1,
+	"SIGUSR2": syscall.SIGUSR2,
+	"SIGTERM": syscall.SIGTERM,
+}
+
+// ParseSignal parses a signal name like SIGTERM or TERM
+func ParseSignal(name string) (syscall.Signal, error) {
+	name = strings.ToUpper(name)
+	if strings.HasPrefix(name, "SIG") == false {
+		name = "SIG" + name
+	}
+
+	sig, ok := signalNames[name]
+	if !ok {
+		return 0, fmt.Errorf("unknown signal '%s'", name)
+	}
+
+	return sig, nil
 }
@@ -0,0 +1,171 @@
+package war
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/doctordesh/check"
+)
+
+func shellTemplate(t *testing.T, script string) RunnableTemplate {
+	t.Helper()
+
+	sh, err := exec.LookPath("sh")
+	check.OK(t, err)
+
+	return RunnableTemplate{
+		BinPath:     sh,
+		Args:        []string{"sh", "-c", script},
+		StopTimeout: time.Millisecond * 200,
+	}
+}
+
+func TestStopEscalatesToSIGKILL(t *testing.T) {
+	r := shellTemplate(t, `trap "" INT TERM; while true; do sleep 0.01; done`).Build(nil)
+
+	err := r.Start()
+	check.OK(t, err)
+
+	// Give the shell time to install its traps
+	time.Sleep(time.Millisecond * 100)
+
+	started := time.Now()
+
+	err = r.Stop()
+	check.OK(t, err)
+
+	check.Assert(t, time.Since(started) >= 2*r.stopTimeout)
+	check.Equals(t, RunningStateStopped, r.State())
+	check.Assert(t, errors.Is(syscall.Kill(r.cmd.Process.Pid, 0), syscall.ESRCH))
+}
+
+func TestTimeoutStopsCommand(t *testing.T) {
+	tpl := shellTemplate(t, `sleep 5`)
+	tpl.Timeout = time.Millisecond * 100
+
+	r := tpl.Build(nil)
+
+	err := r.Start()
+	check.OK(t, err)
+
+	select {
+	case <-r.done:
+	case <-time.After(time.Second * 2):
+		t.Fatal("command was not stopped after its timeout")
+	}
+
+	check.Equals(t, true, r.TimedOut())
+
+	r = shellTemplate(t, `exit 0`).Build(nil)
+	r.timeout = time.Second
+
+	err = r.Start()
+	check.OK(t, err)
+
+	<-r.done
+	check.Equals(t, false, r.TimedOut())
+}
+
+func TestStopWaitsForWholeProcessGroup(t *testing.T) {
+	// The background sleep ignores SIGINT, so it outlives the shell and has to
+	// be stopped with SIGTERM
+	r := shellTemplate(t, `sleep 30 & echo $! > "$0"; wait`).Build(nil)
+
+	pidFile := filepath.Join(t.TempDir(), "pid")
+	r.cmd.Args = append(r.cmd.Args, pidFile)
+
+	err := r.Start()
+	check.OK(t, err)
+
+	time.Sleep(time.Millisecond * 100)
+
+	b, err := os.ReadFile(pidFile)
+	check.OK(t, err)
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	check.OK(t, err)
+
+	err = r.Stop()
+	check.OK(t, err)
+
+	// Once stopped it's gone, or a zombie not yet reaped by init
+	stat, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	check.Assert(t, err != nil || strings.Contains(string(stat), ") Z "))
+}
+
+func TestParseSignal(t *testing.T) {
+	type row struct {
+		Name   string
+		Signal syscall.Signal
+		Valid  bool
+	}
+
+	table := []row{
+		{"SIGINT", syscall.SIGINT, true},
+		{"TERM", syscall.SIGTERM, true},
+		{"hup", syscall.SIGHUP, true},
+		{"SIGFOO", 0, false},
+	}
+
+	for _, row := range table {
+		sig, err := ParseSignal(row.Name)
+		check.EqualsWithMessage(t, row.Valid, err == nil, "for signal %s", row.Name)
+		check.EqualsWithMessage(t, row.Signal, sig, "for signal %s", row.Name)
+	}
+}
+
+func TestShellRunsCommandLine(t *testing.T) {
+	t.Setenv("SHELL", "")
+
+	out := filepath.Join(t.TempDir(), "out")
+	tpl := RunnableTemplate{
+		Args:  []string{"echo lorem | tr a-z A-Z >", out, "&& echo ipsum >>", out},
+		Shell: true,
+	}
+
+	r := tpl.Build(nil)
+	check.Equals(t, "/bin/sh", r.cmd.Path)
+	check.Equals(t, []string{"/bin/sh", "-c", "echo lorem | tr a-z A-Z > " + out + " && echo ipsum >> " + out}, r.cmd.Args)
+
+	err := r.Start()
+	check.OK(t, err)
+
+	<-r.done
+
+	code, err := r.ExitCode()
+	check.OK(t, err)
+	check.Equals(t, 0, code)
+
+	b, err := os.ReadFile(out)
+	check.OK(t, err)
+	check.Equals(t, "LOREM\nipsum\n", string(b))
+}
+
+func TestShellStopKillsPipeline(t *testing.T) {
+	tpl := RunnableTemplate{
+		Args:        []string{"sleep 30 | sleep 30"},
+		Shell:       true,
+		StopTimeout: time.Millisecond * 200,
+	}
+
+	r := tpl.Build(nil)
+
+	err := r.Start()
+	check.OK(t, err)
+
+	time.Sleep(time.Millisecond * 100)
+
+	started := time.Now()
+	err = r.Stop()
+	check.OK(t, err)
+
+	check.Assert(t, time.Since(started) < tpl.StopTimeout)
+}
@@ -1,99 +1,598 @@
 package war
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
+)
+
+// BusyPolicy decides what happens to changes that come in while the command
+// is still running
+type BusyPolicy string
+
+const (
+	// BusyRestart stops the running command and starts it again
+	BusyRestart BusyPolicy = "restart"
+	// BusyQueue lets the running command finish, then runs it once more with
+	// all changes that came in meanwhile
+	BusyQueue BusyPolicy = "queue"
+	// BusyIgnore lets the running command finish and drops the changes
+	BusyIgnore BusyPolicy = "ignore"
+)
+
+// ParseBusyPolicy parses one of 'restart', 'queue' or 'ignore'
+func ParseBusyPolicy(s string) (BusyPolicy, error) {
+	switch p := BusyPolicy(s); p {
+	case BusyRestart, BusyQueue, BusyIgnore:
+		return p, nil
+	}
 
-	"github.com/doctordesh/war/colors"
+	return "", fmt.Errorf("unknown busy policy '%s', must be one of restart, queue or ignore", s)
+}
+
+// RestartPolicy decides if the command is restarted when it exits by itself,
+// without waiting for changes
+type RestartPolicy string
+
+const (
+	// RestartNever waits for changes before running the command again
+	RestartNever RestartPolicy = "never"
+	// RestartAlways restarts the command whenever it exits
+	RestartAlways RestartPolicy = "always"
+	// RestartOnFailure restarts the command when it exits with a non-zero code
+	// or is killed
+	RestartOnFailure RestartPolicy = "on-failure"
 )
 
+// ParseRestartPolicy parses one of 'never', 'always' or 'on-failure'
+func ParseRestartPolicy(s string) (RestartPolicy, error) {
+	switch p := RestartPolicy(s); p {
+	case RestartNever, RestartAlways, RestartOnFailure:
+		return p, nil
+	}
+
+	return "", fmt.Errorf("unknown restart policy '%s', must be one of never, always or on-failure", s)
+}
+
+// restarts returns true if a command exiting with code should be restarted
+func (p RestartPolicy) restarts(code int) bool {
+	switch p {
+	case RestartAlways:
+		return true
+	case RestartOnFailure:
+		return code != 0
+	}
+
+	return false
+}
+
+// maxRestartBackoff is the longest time to wait before restarting the command.
+// A command that ran for longer than it is not considered crash looping.
+const maxRestartBackoff = time.Second * 30
+
+// restartBackoff returns the time to wait before the nth restart in a row,
+// doubling from initial for each one
+func restartBackoff(initial time.Duration, n int) time.Duration {
+	backoff := initial
+	for i := 1; i < n && backoff < maxRestartBackoff; i++ {
+		backoff *= 2
+	}
+
+	if backoff > maxRestartBackoff {
+		return maxRestartBackoff
+	}
+
+	return backoff
+}
+
+// runExit is the exit code of a run of the runner
+type runExit struct {
+	runner *runner
+	code   int
+}
+
+// exitCodeTimedOut is the exit code war uses for runs that timed out, in the
+// Once mode and for deciding on ExitOnSuccess, the same as timeout(1) uses.
+// RunReport keeps the command's own exit code.
+const exitCodeTimedOut = 124
+
 type runner struct {
 	runnableTemplate RunnableTemplate
 	delay            time.Duration
-	ignoreChangesFor time.Duration
+	debounce         time.Duration
+	maxWait          time.Duration
+	onBusy           BusyPolicy
+
+	// clear clears the terminal before each run, and its scrollback too if
+	// clearScrollback is set
+	clear           bool
+	clearScrollback bool
+
+	// onExit decides if the command is restarted when it exits by itself.
+	// Restarts in a row wait restartBackoff, doubling for each, and stop after
+	// maxRestarts until the next change.
+	onExit         RestartPolicy
+	restartBackoff time.Duration
+	maxRestarts    int
+
+	// rerun forces a run, regardless of changes and the busy policy
+	rerun chan struct{}
 
+	// exits gets the exit code of every run, if set. A run whose steps fail
+	// exits with the failed step's code.
+	exits chan<- runExit
+
+	// once is the batch the runner runs once in, in the Once mode
+	once *onceBatch
+
+	hooks Hooks
+	log   Logger
+
+	// command is the running step or command. It's only used from the Run
+	// goroutine, and kept once it exits until its exit is handled.
 	command *runnable
+
+	// pending is the run in progress while it runs its steps or waits for the
+	// delay, nil once the command is started. delayed fires when the delay is
+	// over.
+	pending *pipeline
+	delayed *time.Timer
+}
+
+// pipeline is a run on its way to starting the command
+type pipeline struct {
+	files     []string
+	changedAt time.Time
+
+	// step is the index of the running step
+	step int
 }
 
-func (r *runner) Stop() {
+// Stop stops the run in progress, its running step or command, and drops the
+// rest of it
+func (r *runner) Stop() error {
+	r.pending = nil
+	if r.delayed != nil {
+		stopTimer(r.delayed)
+	}
+
 	if r.command == nil {
-		return
+		return nil
 	}
 
 	err := r.command.Stop()
 	if err != nil {
-		panic(err)
+		return fmt.Errorf("could not stop command: %w", err)
 	}
 
+	return nil
+}
+
+// busy returns true while a run is in progress
+func (r *runner) busy() bool {
+	return r.command != nil || r.pending != nil
 }
 
-func (r *runner) Run(changesHappened <-chan string) {
+// Run waits for changes and runs the command once no more changes have come
+// in for the debounce period, or once changes have been coming in for maxWait.
+// All changes in between are collected into a single batch. The command is
+// run right away if initial is set. Returns when ctx is done, after stopping
+// the command.
+//
+// Everything happens in this goroutine: changes, the exits of steps and the
+// command, reruns and restarts are all handled as they come in.
+func (r *runner) Run(ctx context.Context, changesHappened <-chan string, initial bool) error {
 	var err error
-	lastEventAt := time.Time{}
+
+	batch := newBatch()
+	queued := newBatch()
+
+	debounce := time.NewTimer(time.Hour)
+	debounce.Stop()
+
+	r.delayed = time.NewTimer(time.Hour)
+	r.delayed.Stop()
+
+	// Restarts of the command after it exited by itself, since the last
+	// change
+	restarts := 0
+	restart := time.NewTimer(time.Hour)
+	restart.Stop()
+
+	if initial {
+		r.run(ctx, nil)
+	}
 
 	for {
+		// exited is closed when the running step or command exits
+		var exited <-chan struct{}
+		if r.command != nil {
+			exited = r.command.done
+		}
+
 		select {
+		case <-ctx.Done():
+			return r.stop(ctx)
+
 		case filename, ok := <-changesHappened:
 			if !ok {
-				colors.Blue("event channel closed. quitting...")
-				return
+				return r.stop(ctx)
 			}
 
-			colors.Blue("file changed: %s", filename)
-			if lastEventAt.Add(r.ignoreChangesFor).After(time.Now()) {
-				colors.Yellow(fmt.Sprintf("ignoring %s", filename))
-				continue
+			now := time.Now()
+			batch.add(filename)
+
+			// Wait for a quiet period, but never longer than maxWait after the
+			// first change in the batch
+			wait := r.debounce
+			if r.maxWait > 0 && now.Add(wait).After(batch.first.Add(r.maxWait)) {
+				wait = batch.first.Add(r.maxWait).Sub(now)
 			}
 
-			lastEventAt = time.Now()
+			stopTimer(debounce)
+			debounce.Reset(wait)
+
+		case <-debounce.C:
+			changes := batch
+			batch = newBatch()
 
-			// If there's already a running command, kill it and start over
-			if r.command != nil {
-				colors.Yellow("restarting command")
-				err = r.command.Stop()
-				if err != nil {
-					panic(err)
+			r.hooks.OnChange(r.runnableTemplate.Name, changes.files())
+
+			if r.once != nil && r.once.start(r) == false {
+				continue
+			}
+
+			if r.busy() {
+				switch r.onBusy {
+				case BusyQueue:
+					r.log.Warnf(r.msg("command still running, will run again when it's done"))
+					for _, f := range changes.files() {
+						queued.add(f)
+					}
+					continue
+				case BusyIgnore:
+					r.log.Warnf(r.msg("command still running, ignoring changes"))
+					continue
 				}
 			}
 
-			r.run()
-		default:
+			restarts = 0
+			stopTimer(restart)
+			err = r.restart(ctx, changes)
+			if err != nil {
+				return err
+			}
 
-			time.Sleep(time.Millisecond * 500)
+		case <-r.rerun:
+			if r.once != nil && r.once.start(r) == false {
+				continue
+			}
 
-			if r.command != nil && r.command.State() == RunningStateStopped {
-				code, err := r.command.ExitCode()
-				if err != nil {
-					panic(err)
-				}
+			restarts = 0
+			stopTimer(restart)
+			err = r.restart(ctx, nil)
+			if err != nil {
+				return err
+			}
 
-				if code < 0 {
-					colors.Red("command killed")
-				} else if code > 0 {
-					colors.Red("command exited with code %d", code)
-				} else {
-					colors.Green("command succesful")
-				}
+		case <-restart.C:
+			if r.busy() {
+				continue
+			}
+
+			r.log.Warnf(r.msg("restarting command (%d/%d)"), restarts, r.maxRestarts)
+			r.run(ctx, nil)
+
+		case <-r.delayed.C:
+			r.start(ctx)
 
-				r.command = nil
+		case <-exited:
+			var report RunReport
+
+			step := r.pending != nil
+			if step {
+				r.stepExited(ctx)
+			} else {
+				report = r.reportExit(ctx)
 			}
+
+			// The next step or the delay before the command
+			if r.busy() {
+				continue
+			}
+
+			if queued.len() > 0 {
+				changes := queued
+				queued = newBatch()
+
+				restarts = 0
+				r.run(ctx, changes)
+				continue
+			}
+
+			if step || r.onExit.restarts(report.ExitCode) == false {
+				continue
+			}
+
+			// A command that ran for a while before exiting is not crash looping
+			if report.Duration() > maxRestartBackoff {
+				restarts = 0
+			}
+
+			if restarts >= r.maxRestarts {
+				r.log.Errorf(r.msg("command exited %d times in a row, not restarting it until files change"), restarts+1)
+				continue
+			}
+
+			restarts++
+			wait := restartBackoff(r.restartBackoff, restarts)
+			r.log.Warnf(r.msg("restarting command in %s"), wait)
+			restart.Reset(wait)
 		}
 	}
 }
 
-// run ...
-func (r *runner) run() {
+// restart stops the run in progress, if any, and runs the command again. A
+// command that already exited is reported as exited, its exit may just not
+// have been handled yet.
+func (r *runner) restart(ctx context.Context, changes *batch) error {
+	if r.pending == nil && r.command != nil && r.command.State() == RunningStateStopped {
+		r.reportExit(ctx)
+	}
+
+	if r.busy() {
+		r.log.Warnf(r.msg("restarting command"))
+
+		err := r.stop(ctx)
+		if err != nil {
+			return err
+		}
+
+		r.command = nil
+	}
+
+	r.run(ctx, changes)
+
+	return nil
+}
+
+// stop stops the run in progress, and reports the command if it was started.
+// It's reported as stopped by war, unless it had already exited. The command
+// is kept, to show how it ended.
+func (r *runner) stop(ctx context.Context) error {
+	started := r.pending == nil && r.command != nil
+	stopped := started && r.command.State() == RunningStateRunning
+
+	err := r.Stop()
+	if err != nil {
+		return err
+	}
+
+	if started {
+		r.report(ctx, stopped)
+	}
+
+	return nil
+}
+
+// reportExit reports how the exited command ran, and clears it
+func (r *runner) reportExit(ctx context.Context) RunReport {
+	report := r.report(ctx, false)
+	r.command = nil
+
+	return report
+}
+
+// report reports how the command ran, stopped is set if war stopped it
+func (r *runner) report(ctx context.Context, stopped bool) RunReport {
+	report := r.command.Report()
+	report.Name = r.runnableTemplate.Name
+	report.Dir = r.runnableTemplate.Dir
+	report.Stopped = stopped
+
+	r.hooks.OnExit(report)
+
+	code := report.ExitCode
+	if report.TimedOut {
+		code = exitCodeTimedOut
+	}
+	r.exited(ctx, code)
+
+	return report
+}
+
+// exited sends the exit code of a run on exits, if set, unless ctx is done
+func (r *runner) exited(ctx context.Context, code int) {
+	if r.exits == nil {
+		return
+	}
+
+	select {
+	case r.exits <- runExit{runner: r, code: code}:
+	case <-ctx.Done():
+	}
+}
+
+// run starts a run, changes are the changed files that triggered it, nil if
+// it wasn't triggered by changes. If the command has steps they are run to
+// completion first, and if one of them fails the command is not started.
+func (r *runner) run(ctx context.Context, changes *batch) {
+	r.pending = &pipeline{}
+	if changes != nil {
+		r.pending.files = changes.files()
+		r.pending.changedAt = changes.first
+	}
+
+	if r.clear {
+		clearScreen(r.clearScrollback)
+	}
+
+	r.next(ctx)
+}
+
+// next runs the next step, or waits for the delay before starting the command
+// once all steps are done
+func (r *runner) next(ctx context.Context) {
+	if r.pending.step < len(r.runnableTemplate.Steps) {
+		r.runStep(ctx)
+		return
+	}
+
+	r.delayed.Reset(r.delay)
+}
+
+// start starts the command of the pending run
+func (r *runner) start(ctx context.Context) {
 	var err error
 
-	r.command = r.runnableTemplate.Build()
+	// The run was stopped, or is still running a step
+	if r.pending == nil || r.command != nil {
+		return
+	}
+
+	files, changedAt := r.pending.files, r.pending.changedAt
+	r.pending = nil
 
-	// Delay before running next command
-	time.Sleep(r.delay)
+	r.command = r.runnableTemplate.Build(files)
+	r.command.changedAt = changedAt
+	r.command.log = r.log
 
-	colors.Blue("running command: %s", r.command.cmd.String())
 	err = r.command.Start()
 	if err != nil {
-		panic(err)
+		r.log.Errorf(r.msg("could not run command %s: %v"), r.command.cmd.String(), err)
+		r.command = nil
+		r.exited(ctx, 1)
+		return
+	}
+
+	r.hooks.OnStart(r.runnableTemplate.Name, r.command.cmd.Args, r.command.cmd.Process.Pid)
+
+	if r.runnableTemplate.Ready != nil {
+		go r.reportReady(r.command, *r.runnableTemplate.Ready)
+	}
+}
+
+// reportReady waits for the command to pass the ready check and reports it.
+// Nothing is reported if it exits first, as the exit is reported.
+func (r *runner) reportReady(command *runnable, check ReadyCheck) {
+	took, err := command.waitReady(check)
+	if errors.Is(err, ErrExitedBeforeReady) {
+		return
+	}
+
+	if err != nil {
+		r.log.Errorf(r.msg("command not ready after %s"), check.timeout())
+		return
+	}
+
+	r.log.Successf(r.msg("command ready in %s"), formatDuration(took))
+}
+
+// runStep starts the pending run's step, its exit is handled by stepExited
+func (r *runner) runStep(ctx context.Context) {
+	i := r.pending.step
+	step := r.runnableTemplate.Steps[i]
+
+	r.command = step.Build(r.pending.files)
+	r.command.log = r.log
+
+	r.log.Infof(r.msg("running step %d/%d: %s"), i+1, len(r.runnableTemplate.Steps), r.command.cmd.String())
+	err := r.command.Start()
+	if err != nil {
+		r.log.Errorf(r.msg("step %d (%s) failed: %v"), i+1, stepName(step), err)
+		r.command = nil
+		r.pending = nil
+		r.exited(ctx, 1)
+	}
+}
+
+// stepExited moves the pending run on to its next step if the step succeeded,
+// or drops it if the step failed
+func (r *runner) stepExited(ctx context.Context) {
+	i := r.pending.step
+	step := r.runnableTemplate.Steps[i]
+
+	code := r.command.Report().ExitCode
+	timedOut := r.command.TimedOut()
+	r.command = nil
+
+	if timedOut {
+		r.log.Errorf(r.msg("step %d (%s) timed out after %s, not running command"), i+1, stepName(step), step.Timeout)
+		r.pending = nil
+		r.exited(ctx, exitCodeTimedOut)
+		return
+	}
+
+	if code != 0 {
+		r.log.Errorf(r.msg("step %d (%s) failed with code %d, not running command"), i+1, stepName(step), code)
+		r.pending = nil
+		r.exited(ctx, code)
+		return
+	}
+
+	r.pending.step++
+	r.next(ctx)
+}
+
+// stepName returns the name of the step, or its command if it has none
+func stepName(step RunnableTemplate) string {
+	if step.Name != "" {
+		return step.Name
 	}
 
+	return strings.Join(step.Args, " ")
+}
+
+// stopTimer stops the timer and drains it, so it can be reset
+func stopTimer(t *time.Timer) {
+	if !t.Stop() {
+		select {
+		case <-t.C:
+		default:
+		}
+	}
+}
+
+// msg prefixes the message format with the name of the command, if it has one
+func (r *runner) msg(format string) string {
+	return prefix(r.runnableTemplate.Name, format)
+}
+
+// batch is the ordered set of files changed since the last run
+type batch struct {
+	order []string
+	seen  map[string]bool
+
+	// first is when the first file was added
+	first time.Time
+}
+
+func newBatch() *batch {
+	return &batch{seen: map[string]bool{}}
+}
+
+// add adds the file to the batch, returns false if it was already in it
+func (b *batch) add(filename string) bool {
+	if b.seen[filename] {
+		return false
+	}
+
+	if len(b.order) == 0 {
+		b.first = time.Now()
+	}
+
+	b.seen[filename] = true
+	b.order = append(b.order, filename)
+
+	return true
+}
+
+func (b *batch) len() int {
+	return len(b.order)
+}
+
+func (b *batch) files() []string {
+	return b.order
 }
@@ -0,0 +1,418 @@
+package war
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/doctordesh/check"
+	"github.com/doctordesh/war/colors"
+)
+
+func TestRestartPolicy(t *testing.T) {
+	type row struct {
+		Policy   RestartPolicy
+		Code     int
+		Expected bool
+	}
+
+	table := []row{
+		{RestartNever, 0, false},
+		{RestartNever, 1, false},
+		{RestartAlways, 0, true},
+		{RestartAlways, 1, true},
+		{RestartOnFailure, 0, false},
+		{RestartOnFailure, 1, true},
+		{RestartOnFailure, -1, true},
+	}
+
+	for _, row := range table {
+		check.EqualsWithMessage(t, row.Expected, row.Policy.restarts(row.Code), "for policy %s and code %d", row.Policy, row.Code)
+	}
+
+	_, err := ParseRestartPolicy("sometimes")
+	check.NotOK(t, err)
+}
+
+func TestRestartBackoff(t *testing.T) {
+	type row struct {
+		N        int
+		Expected time.Duration
+	}
+
+	table := []row{
+		{1, time.Second},
+		{2, time.Second * 2},
+		{3, time.Second * 4},
+		{5, time.Second * 16},
+		{6, time.Second * 30},
+		{100, time.Second * 30},
+	}
+
+	for _, row := range table {
+		check.EqualsWithMessage(t, row.Expected, restartBackoff(time.Second, row.N), "for restart %d", row.N)
+	}
+}
+
+// newTestRunner returns a runner of the template that sends its exit codes on
+// the returned channel
+func newTestRunner(tpl RunnableTemplate, hooks Hooks) (*runner, <-chan runExit) {
+	// Buffered, so runs don't wait for the test to receive their exits
+	exits := make(chan runExit, 10)
+
+	r := &runner{
+		runnableTemplate: tpl,
+		debounce:         time.Millisecond * 10,
+		onBusy:           BusyRestart,
+		onExit:           RestartNever,
+		rerun:            make(chan struct{}, 1),
+		exits:            exits,
+		hooks:            hooks,
+		log:              colors.NewLogger(io.Discard, false),
+	}
+
+	return r, exits
+}
+
+// startRunner runs the runner until the test is done, and waits for it to
+// stop its command before the test's temporary directories are removed
+func startRunner(t *testing.T, r *runner, changes <-chan string, initial bool) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		r.Run(ctx, changes, initial)
+	}()
+
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+}
+
+func TestRunnerReportsExitRightAway(t *testing.T) {
+	h := &recordHooks{}
+	r, exits := newTestRunner(shellTemplate(t, `exit 2`), h)
+
+	startRunner(t, r, make(chan string), true)
+
+	// Exits used to be picked up by polling every 500ms
+	select {
+	case exit := <-exits:
+		check.Equals(t, 2, exit.code)
+	case <-time.After(time.Millisecond * 450):
+		t.Fatal("exit was not reported before the next poll would have been")
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	check.Equals(t, 1, len(h.exits))
+}
+
+func TestRunnerReportsExitBeforeRestart(t *testing.T) {
+	h := &recordHooks{}
+	r, exits := newTestRunner(shellTemplate(t, `exit 0`), h)
+	r.delayed = time.NewTimer(time.Hour)
+
+	r.command = r.runnableTemplate.Build(nil)
+	err := r.command.Start()
+	check.OK(t, err)
+	<-r.command.done
+
+	// The exit is not handled yet when the change comes in
+	err = r.restart(context.Background(), nil)
+	check.OK(t, err)
+
+	check.Equals(t, 0, (<-exits).code)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	check.Equals(t, 1, len(h.exits))
+}
+
+func TestRunnerChangeStopsStep(t *testing.T) {
+	marker := filepath.Join(t.TempDir(), "marker")
+
+	tpl := shellTemplate(t, `exit 7`)
+	tpl.Steps = []RunnableTemplate{shellTemplate(t, `test -f `+marker+` || sleep 5`)}
+
+	r, exits := newTestRunner(tpl, NopHooks{})
+
+	changes := make(chan string)
+	startRunner(t, r, changes, true)
+
+	// The step of the initial run sleeps, the one of the restarted run passes
+	time.Sleep(time.Millisecond * 100)
+	err := os.WriteFile(marker, nil, 0666)
+	check.OK(t, err)
+	changes <- marker
+
+	select {
+	case exit := <-exits:
+		check.Equals(t, 7, exit.code)
+	case <-time.After(time.Second * 2):
+		t.Fatal("step was not stopped by the change")
+	}
+}
+
+// sendChanges sends the files to the runner, waiting between them
+func sendChanges(changes chan<- string, between time.Duration, files ...string) {
+	for _, f := range files {
+		changes <- f
+		time.Sleep(between)
+	}
+}
+
+func TestRunnerDebouncesBurstIntoOneRun(t *testing.T) {
+	h := &recordHooks{}
+	r, exits := newTestRunner(shellTemplate(t, `exit 0`), h)
+	r.debounce = time.Millisecond * 100
+
+	changes := make(chan string)
+	startRunner(t, r, changes, false)
+
+	sendChanges(changes, time.Millisecond*10, "a.go", "b.go", "a.go", "c.go")
+
+	select {
+	case <-exits:
+	case <-time.After(time.Second * 2):
+		t.Fatal("burst did not trigger a run")
+	}
+
+	// No other run follows the one for the burst
+	select {
+	case <-exits:
+		t.Fatal("burst triggered more than one run")
+	case <-time.After(r.debounce * 3):
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	check.Equals(t, 1, h.starts)
+	check.Equals(t, []string{"a.go", "b.go", "c.go"}, h.exits[0].Files)
+}
+
+func TestRunnerRunsAfterMaxWait(t *testing.T) {
+	h := &recordHooks{}
+	r, _ := newTestRunner(shellTemplate(t, `exit 0`), h)
+	r.debounce = time.Millisecond * 100
+	r.maxWait = time.Millisecond * 200
+
+	changes := make(chan string)
+	startRunner(t, r, changes, false)
+
+	// Changes keep coming in faster than the debounce period, for longer than
+	// maxWait
+	files := []string{}
+	for i := 0; i < 30; i++ {
+		files = append(files, fmt.Sprintf("%d.go", i))
+	}
+	sendChanges(changes, time.Millisecond*20, files...)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	check.Assert(t, len(h.changes) > 0)
+	check.Assert(t, len(h.changes[0]) < len(files))
+}
+
+func TestRunnerQueuesChangesWhileBusy(t *testing.T) {
+	h := &recordHooks{}
+	r, exits := newTestRunner(shellTemplate(t, `sleep 0.3`), h)
+	r.onBusy = BusyQueue
+
+	changes := make(chan string)
+	startRunner(t, r, changes, true)
+
+	time.Sleep(time.Millisecond * 50)
+	sendChanges(changes, time.Millisecond*50, "a.go", "b.go", "a.go")
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-exits:
+		case <-time.After(time.Second * 2):
+			t.Fatalf("run %d did not exit", i+1)
+		}
+	}
+
+	// The queued changes are run exactly once
+	select {
+	case <-exits:
+		t.Fatal("queued changes ran more than once")
+	case <-time.After(time.Millisecond * 500):
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	check.Equals(t, 2, h.starts)
+	check.Equals(t, 0, len(h.exits[0].Files))
+	check.Equals(t, []string{"a.go", "b.go"}, h.exits[1].Files)
+}
+
+func TestRunnerIgnoresChangesWhileBusy(t *testing.T) {
+	h := &recordHooks{}
+	r, exits := newTestRunner(shellTemplate(t, `sleep 0.3`), h)
+	r.onBusy = BusyIgnore
+
+	changes := make(chan string)
+	startRunner(t, r, changes, true)
+
+	time.Sleep(time.Millisecond * 50)
+	sendChanges(changes, time.Millisecond*50, "a.go", "b.go")
+
+	select {
+	case <-exits:
+	case <-time.After(time.Second * 2):
+		t.Fatal("run did not exit")
+	}
+
+	select {
+	case <-exits:
+		t.Fatal("changes while busy were run")
+	case <-time.After(time.Millisecond * 500):
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	check.Equals(t, 1, h.starts)
+}
+
+func TestRunnerFailingStepStopsChain(t *testing.T) {
+	dir := t.TempDir()
+	h := &recordHooks{}
+
+	tpl := shellTemplate(t, `touch command`)
+	tpl.Dir = dir
+	tpl.Steps = []RunnableTemplate{shellTemplate(t, `exit 4`), shellTemplate(t, `touch step`)}
+	tpl.Steps[1].Dir = dir
+
+	r, exits := newTestRunner(tpl, h)
+	startRunner(t, r, make(chan string), true)
+
+	select {
+	case exit := <-exits:
+		check.Equals(t, 4, exit.code)
+	case <-time.After(time.Second * 2):
+		t.Fatal("failed step was not reported")
+	}
+
+	// Give a wrongly started step or command time to run
+	time.Sleep(time.Millisecond * 200)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	check.Equals(t, 0, h.starts)
+
+	_, err := os.Stat(filepath.Join(dir, "step"))
+	check.Assert(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(dir, "command"))
+	check.Assert(t, os.IsNotExist(err))
+}
+
+func TestRunnerRestartsCrashingCommand(t *testing.T) {
+	h := &recordHooks{}
+	r, exits := newTestRunner(shellTemplate(t, `exit 1`), h)
+	r.onExit = RestartOnFailure
+	r.restartBackoff = time.Millisecond * 10
+	r.maxRestarts = 2
+
+	changes := make(chan string)
+	startRunner(t, r, changes, true)
+
+	// The run, and the restarts after it, until maxRestarts. A change resets
+	// the restarts.
+	for round := 0; round < 2; round++ {
+		if round > 0 {
+			changes <- "main.go"
+		}
+
+		for i := 0; i < 1+r.maxRestarts; i++ {
+			select {
+			case exit := <-exits:
+				check.Equals(t, 1, exit.code)
+			case <-time.After(time.Second * 2):
+				t.Fatalf("run %d of round %d did not exit", i+1, round+1)
+			}
+		}
+
+		select {
+		case <-exits:
+			t.Fatalf("command was restarted more than %d times in round %d", r.maxRestarts, round+1)
+		case <-time.After(time.Millisecond * 300):
+		}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	check.Equals(t, 6, h.starts)
+}
+
+func TestRunnerReportsStoppedCommands(t *testing.T) {
+	h := &recordHooks{}
+	r, _ := newTestRunner(shellTemplate(t, `sleep 5`), h)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	changes := make(chan string)
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		r.Run(ctx, changes, true)
+	}()
+
+	// The first run is stopped to restart it, the second when Run returns
+	time.Sleep(time.Millisecond * 100)
+	changes <- "main.go"
+	time.Sleep(time.Millisecond * 100)
+	cancel()
+	<-done
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	check.Equals(t, 2, h.starts)
+	check.Equals(t, 2, len(h.exits))
+
+	for _, report := range h.exits {
+		check.Equals(t, true, report.Stopped)
+		check.Equals(t, "SIGINT", report.Signal)
+	}
+	check.Equals(t, []string{"main.go"}, h.exits[1].Files)
+}
+
+func TestRunnerTimedOutKeepsExitCode(t *testing.T) {
+	h := &recordHooks{}
+
+	tpl := shellTemplate(t, `sleep 5`)
+	tpl.Timeout = time.Millisecond * 100
+
+	r, exits := newTestRunner(tpl, h)
+	startRunner(t, r, make(chan string), true)
+
+	select {
+	case exit := <-exits:
+		check.Equals(t, exitCodeTimedOut, exit.code)
+	case <-time.After(time.Second * 2):
+		t.Fatal("timed out run was not reported")
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	// Killed by the stop signal
+	check.Equals(t, true, h.exits[0].TimedOut)
+	check.Equals(t, -1, h.exits[0].ExitCode)
+	check.Equals(t, "SIGINT", h.exits[0].Signal)
+}
@@ -0,0 +1,106 @@
+package war
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// isTerminal returns true if the file is a terminal
+func isTerminal(f *os.File) bool {
+	_, err := unix.IoctlGetTermios(int(f.Fd()), ioctlGetTermios)
+	return err == nil
+}
+
+// isForeground returns true if war's process group owns the terminal. Reading
+// from it otherwise stops war with SIGTTIN.
+func isForeground(f *os.File) bool {
+	pgrp, err := unix.IoctlGetInt(int(f.Fd()), unix.TIOCGPGRP)
+	if err != nil {
+		return false
+	}
+
+	return pgrp == syscall.Getpgrp()
+}
+
+// foregroundTerminal returns r as a file if it's a terminal that war's process
+// group owns, so a command reading it can be given the terminal
+func foregroundTerminal(r io.Reader) (*os.File, bool) {
+	f, ok := r.(*os.File)
+	if !ok || f == nil {
+		return nil, false
+	}
+
+	if isTerminal(f) == false || isForeground(f) == false {
+		return nil, false
+	}
+
+	return f, true
+}
+
+// terminalMu makes sure SIGTTOU is ignored until every takeTerminal is done
+var terminalMu sync.Mutex
+
+// takeTerminal gives the terminal back to war's process group after the
+// command in the process group pgid exits. It's left alone if another
+// command's process group has taken it since.
+func takeTerminal(f *os.File, pgid int) {
+	fd := int(f.Fd())
+
+	pgrp, err := unix.IoctlGetInt(fd, unix.TIOCGPGRP)
+	if err != nil || (pgrp != pgid && pgrp != syscall.Getpgrp()) {
+		return
+	}
+
+	terminalMu.Lock()
+	defer terminalMu.Unlock()
+
+	// Setting the foreground process group from the background sends SIGTTOU
+	signal.Ignore(syscall.SIGTTOU)
+	defer signal.Reset(syscall.SIGTTOU)
+
+	unix.IoctlSetPointerInt(fd, unix.TIOCSPGRP, syscall.Getpgrp())
+}
+
+// makeCbreak turns off line buffering and echo on the terminal, so single key
+// presses can be read. Output processing and signals like ctrl-c are left as
+// they are. The returned function restores the terminal.
+func makeCbreak(f *os.File) (func(), error) {
+	fd := int(f.Fd())
+
+	old, err := unix.IoctlGetTermios(fd, ioctlGetTermios)
+	if err != nil {
+		return nil, fmt.Errorf("could not get terminal attributes: %w", err)
+	}
+
+	cbreak := *old
+	cbreak.Lflag &^= unix.ICANON | unix.ECHO
+	cbreak.Cc[unix.VMIN] = 1
+	cbreak.Cc[unix.VTIME] = 0
+
+	err = unix.IoctlSetTermios(fd, ioctlSetTermios, &cbreak)
+	if err != nil {
+		return nil, fmt.Errorf("could not set terminal attributes: %w", err)
+	}
+
+	return func() { unix.IoctlSetTermios(fd, ioctlSetTermios, old) }, nil
+}
+
+// clearScreen clears the terminal, and optionally its scrollback. Does nothing
+// if stdout is not a terminal.
+func clearScreen(scrollback bool) {
+	if isTerminal(os.Stdout) == false {
+		return
+	}
+
+	if scrollback {
+		fmt.Fprint(os.Stdout, "\033[3J")
+	}
+
+	fmt.Fprint(os.Stdout, "\033[H\033[2J")
+}
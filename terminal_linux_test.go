@@ -0,0 +1,148 @@
+package war
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/doctordesh/check"
+	"golang.org/x/sys/unix"
+)
+
+// openPty opens a new pseudo terminal, returning its master and slave ends
+func openPty(t *testing.T) (*os.File, *os.File) {
+	t.Helper()
+
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		t.Skipf("could not open a pty: %v", err)
+	}
+	t.Cleanup(func() { master.Close() })
+
+	fd := int(master.Fd())
+	err = unix.IoctlSetPointerInt(fd, unix.TIOCSPTLCK, 0)
+	check.OK(t, err)
+
+	n, err := unix.IoctlGetInt(fd, unix.TIOCGPTN)
+	check.OK(t, err)
+
+	slave, err := os.OpenFile(fmt.Sprintf("/dev/pts/%d", n), os.O_RDWR|syscall.O_NOCTTY, 0)
+	check.OK(t, err)
+	t.Cleanup(func() { slave.Close() })
+
+	return master, slave
+}
+
+// TestCommandReadsTerminal runs a command with the terminal as stdin, from a
+// helper process that has the pty as its controlling terminal, like war run
+// with --no-keys in a shell
+func TestCommandReadsTerminal(t *testing.T) {
+	if os.Getenv("WAR_TEST_TERMINAL") == "1" {
+		runTerminalHelper()
+		return
+	}
+
+	master, slave := openPty(t)
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestCommandReadsTerminal$")
+	cmd.Env = append(os.Environ(), "WAR_TEST_TERMINAL=1")
+	cmd.Stdin = slave
+	cmd.Stdout = slave
+	cmd.Stderr = slave
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true, Setctty: true, Ctty: 0}
+
+	err := cmd.Start()
+	check.OK(t, err)
+	slave.Close()
+
+	out := &lockedBuffer{}
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, err := master.Read(buf)
+			out.Write(buf[:n])
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	waitFor(t, out, "reading")
+	_, err = master.Write([]byte("hello\n"))
+	check.OK(t, err)
+
+	waitFor(t, out, "helper done")
+	check.OK(t, cmd.Wait())
+
+	check.AssertWithMessage(t, strings.Contains(out.String(), "got hello"), "command did not read the terminal: %q", out.String())
+	check.AssertWithMessage(t, strings.Contains(out.String(), "foreground true"), "war did not get the terminal back: %q", out.String())
+}
+
+// runTerminalHelper runs a command reading stdin, and reports if it could read
+// it and if the helper has the terminal again after it exited
+func runTerminalHelper() {
+	tpl := RunnableTemplate{
+		BinPath: "/bin/sh",
+		Args:    []string{"sh", "-c", "echo reading; read line; echo got $line"},
+		Stdin:   os.Stdin,
+		Stdout:  os.Stdout,
+		Stderr:  os.Stderr,
+
+		StopTimeout: time.Millisecond * 200,
+	}
+
+	r := tpl.Build(nil)
+	err := r.Start()
+	if err != nil {
+		fmt.Println("could not start:", err)
+		os.Exit(1)
+	}
+
+	select {
+	case <-r.done:
+	case <-time.After(time.Second * 5):
+		fmt.Println("command did not exit")
+		r.Stop()
+	}
+
+	fmt.Println("foreground", isForeground(os.Stdin))
+	fmt.Println("helper done")
+	os.Exit(0)
+}
+
+func waitFor(t *testing.T, out *lockedBuffer, s string) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second * 10)
+	for strings.Contains(out.String(), s) == false {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %q, got %q", s, out.String())
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.String()
+}
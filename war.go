@@ -1,64 +1,569 @@
 package war
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"os/signal"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/doctordesh/war/colors"
+	"github.com/fsnotify/fsnotify"
 )
 
-type watchAndRun struct {
+// ExitError is returned by Run in the Once mode when the run fails
+type ExitError struct {
+	Code int
+}
+
+func (e *ExitError) Error() string {
+	if e.Code < 0 {
+		return "command killed"
+	}
+
+	return fmt.Sprintf("command exited with code %d", e.Code)
+}
+
+// War watches a directory and runs commands when files in it change. Create it
+// with New, add more commands with AddRunnable and start it with Run.
+type War struct {
 	watcher *watcher
-	runner  *runner
+	runners []*runner
 
 	Verbose bool
+
+	// GitIgnore makes changes to files ignored by .gitignore files not
+	// trigger a run. Enabled by default.
+	GitIgnore bool
+
+	// IgnoreFile is an extra file with ignore rules, read in addition to the
+	// .warignore file in the watched directory
+	IgnoreFile string
+
+	// Poll makes war poll for changes every PollInterval instead of using
+	// inotify. War also falls back to polling if inotify can't be used.
+	Poll         bool
+	PollInterval time.Duration
+
+	// MaxWait is the longest time a run is postponed while changes keep
+	// coming in faster than the debounce period. Zero means no limit.
+	MaxWait time.Duration
+
+	// OnBusy decides what happens to changes while the command is running.
+	// Defaults to BusyRestart.
+	OnBusy BusyPolicy
+
+	// RestartOnExit decides if the command is restarted when it exits by
+	// itself. Restarts in a row wait RestartBackoff, doubling for each up to
+	// 30s, and stop after MaxRestarts until files change. Defaults to
+	// RestartNever.
+	RestartOnExit  RestartPolicy
+	RestartBackoff time.Duration
+	MaxRestarts    int
+
+	// ExitOnSuccess makes Run return once the last runs of all commands have
+	// succeeded, instead of watching until interrupted
+	ExitOnSuccess bool
+
+	// Once makes Run wait for the first change, run the commands it's
+	// relevant for once and return when all of them have exited. Later
+	// changes don't start any runs. Returns an ExitError if a run failed.
+	Once bool
+
+	// Hooks are called on the events in war's lifecycle, in order. Defaults
+	// to LogHooks printing them with the Logger, if nil.
+	Hooks []Hooks
+
+	// Logger prints war's messages. Defaults to colors.Default(), printing
+	// them in color to stderr.
+	Logger Logger
+
+	// Clear clears the terminal before each run, and its scrollback too if
+	// ClearScrollback is set. Does nothing if stdout is not a terminal.
+	Clear           bool
+	ClearScrollback bool
+
+	// Keys enables single key commands when stdin is a terminal, see
+	// printKeyHelp. The commands' Stdin is ignored while it's enabled.
+	Keys bool
+
+	// paused is set while watching is paused from the keyboard
+	paused int32
+
+	// once is the batch of runners run in the Once mode
+	once *onceBatch
 }
 
-func New(directoryToWatch string, runnable RunnableTemplate, delay, ignoreChangesFor time.Duration) *watchAndRun {
-	w := &watcher{
-		directory: directoryToWatch,
-		exclude:   runnable.Excludes,
-		verbose:   false,
+// New returns a War that runs the command once no changes have come in
+// for the debounce period, after waiting delay.
+func New(directoryToWatch string, runnable RunnableTemplate, delay, debounce time.Duration) *War {
+	w := &War{
+		watcher: &watcher{
+			directory: directoryToWatch,
+			verbose:   false,
+		},
+		GitIgnore:    true,
+		PollInterval: time.Millisecond * 500,
+		MaxWait:      time.Second * 2,
+		OnBusy:       BusyRestart,
+
+		RestartOnExit:  RestartNever,
+		RestartBackoff: time.Second,
+		MaxRestarts:    5,
+
+		Logger: colors.Default(),
 	}
-	r := &runner{
+
+	w.AddRunnable(runnable, delay, debounce)
+
+	return w
+}
+
+// AddRunnable adds another command to run, sharing the watcher with the
+// others. Each command is only run on changes matching its own Matches and
+// Excludes.
+func (w *War) AddRunnable(runnable RunnableTemplate, delay, debounce time.Duration) {
+	w.runners = append(w.runners, &runner{
 		runnableTemplate: runnable,
 		delay:            delay,
-		ignoreChangesFor: ignoreChangesFor,
-	}
+		debounce:         debounce,
+		rerun:            make(chan struct{}, 1),
+	})
 
-	return &watchAndRun{w, r, false}
+	w.watcher.excludes = append(w.watcher.excludes, runnable.Excludes)
 }
 
-func (w *watchAndRun) WatchAndRun() error {
-	// w.watcher.SetVerboseLogging(w.Verbose)
-	// w.runner.SetVerboseLogging(w.Verbose)
+// WatchAndRun runs until interrupted with SIGINT.
+//
+// Deprecated: use Run, which leaves signal handling to the caller.
+func (w *War) WatchAndRun() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
-	// Setup signals
 	sigs := make(chan os.Signal, 1)
 	signal.Notify(sigs, syscall.SIGINT)
+	defer signal.Stop(sigs)
+
+	go func() {
+		select {
+		case <-sigs:
+			fmt.Fprintln(os.Stderr)
+			w.Logger.Infof("keyboard interrupt detected, quiting...")
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return w.Run(ctx)
+}
+
+// Run watches and runs the commands until ctx is done, or until a run exits in
+// the ExitOnSuccess and Once modes. The running commands are stopped before it
+// returns. Returns nil when ctx is done, an ExitError if the run it quit after
+// failed, or an error if watching or stopping a command fails.
+func (w *War) Run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// w.watcher.SetVerboseLogging(w.Verbose)
+
+	// The poll interval is also used when falling back to polling
+	if w.PollInterval <= 0 {
+		return fmt.Errorf("poll interval must be positive, got %s", w.PollInterval)
+	}
+
+	warIgnore, err := newWarIgnore(w.watcher.directory, w.IgnoreFile)
+	if err != nil {
+		return err
+	}
+
+	ignorer := ignorers{warIgnore}
+	if w.GitIgnore {
+		ignorer = append(ignorer, newGitIgnore(os.DirFS(w.watcher.directory)))
+	}
+
+	if w.Logger == nil {
+		w.Logger = colors.Default()
+	}
+
+	w.watcher.ignorer = ignorer
+	h := hooks(w.Hooks)
+	if w.Hooks == nil {
+		h = hooks{LogHooks{Logger: w.Logger}}
+	}
+
+	w.watcher.hooks = h
+	w.watcher.log = w.Logger
+	w.watcher.poll = w.Poll
+	w.watcher.pollInterval = w.PollInterval
+
+	// Exits are only needed for returning after a run
+	var exits chan runExit
+	if w.ExitOnSuccess || w.Once {
+		exits = make(chan runExit, len(w.runners))
+	}
+
+	w.once = nil
+	if w.Once {
+		w.once = newOnceBatch()
+	}
+
+	changes := make([]chan string, len(w.runners))
+	for i, r := range w.runners {
+		r.maxWait = w.MaxWait
+		r.onBusy = w.OnBusy
+		r.onExit = w.RestartOnExit
+		r.restartBackoff = w.RestartBackoff
+		r.maxRestarts = w.MaxRestarts
+		r.clear = w.Clear || w.ClearScrollback
+		r.clearScrollback = w.ClearScrollback
+		r.exits = exits
+		r.hooks = h
+		r.log = w.Logger
+
+		r.once = w.once
+		if w.Once {
+			r.onBusy = BusyIgnore
+			r.onExit = RestartNever
+		}
+		changes[i] = make(chan string)
+	}
+
+	// Each runner collects its changes in an inbox, so a runner that is busy
+	// stopping its command doesn't hold up the changes for the others
+	inboxes := make([]chan string, len(w.runners))
+	for i := range w.runners {
+		inboxes[i] = make(chan string)
+		go collectChanges(ctx, inboxes[i], changes[i])
+	}
+
+	// Keys are read from stdin, so the commands can't have it
+	keys := make(chan byte)
+	if w.Keys && isTerminal(os.Stdin) && isForeground(os.Stdin) {
+		restore, err := makeCbreak(os.Stdin)
+		if err != nil {
+			return err
+		}
+		defer restore()
+
+		for _, r := range w.runners {
+			r.runnableTemplate.Stdin = nil
+			for i := range r.runnableTemplate.Steps {
+				r.runnableTemplate.Steps[i].Stdin = nil
+			}
+		}
+
+		go readKeys(ctx, os.Stdin, keys)
+		printKeyHelp(w.Logger)
+	}
 
 	// Run
-	c, err := w.watcher.Watch()
+	events, watchErrs, err := w.watcher.Watch(ctx)
 	if err != nil {
 		return err
 	}
 
-	go w.runner.Run(c)
+	go w.fanOut(ctx, events, inboxes)
+
+	// The runners make an initial run, unless waiting for the first change,
+	// and stop their commands when ctx is done, to not leak processes
+	runErrs := make(chan error, len(w.runners))
+	wg := sync.WaitGroup{}
+	for i, r := range w.runners {
+		wg.Add(1)
+		go func(r *runner, changes <-chan string) {
+			defer wg.Done()
+
+			err := r.Run(ctx, changes, w.Once == false)
+			if err != nil {
+				runErrs <- err
+			}
+		}(r, changes[i])
+	}
+
+	reason, err := w.wait(ctx, keys, exits, watchErrs, runErrs)
+
+	cancel()
+	wg.Wait()
+
+	if reason != "" {
+		w.Logger.Infof("%s, quiting...", reason)
+	}
+
+	// Stopping errors are only returned if there is no other error
+	if err == nil {
+		select {
+		case err = <-runErrs:
+		default:
+		}
+	}
+
+	return err
+}
+
+// wait acts on key presses until war should quit, either from the keyboard,
+// ctx being done, an error, or the runs exiting in the ExitOnSuccess and Once
+// modes. Returns why it quit, empty if ctx is done, and an ExitError if the
+// runs it quit after failed.
+//
+// In the Once mode it quits once every command in the batch has run, with the
+// worst exit code. In the ExitOnSuccess mode it quits once the last runs of all
+// commands have succeeded.
+func (w *War) wait(ctx context.Context, keys <-chan byte, exits <-chan runExit, watchErrs, runErrs <-chan error) (string, error) {
+	// The exit code of the last run of each command
+	codes := map[*runner]int{}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return "", nil
+
+		case err := <-watchErrs:
+			w.watcher.hooks.OnError(err)
+			return "could not watch for changes", err
 
-	// Make an initial run
-	w.runner.run()
+		case err := <-runErrs:
+			w.watcher.hooks.OnError(err)
+			return "could not run command", err
 
-	<-sigs
+		case exit := <-exits:
+			if w.Once {
+				if _, ok := codes[exit.runner]; ok == false {
+					codes[exit.runner] = exit.code
+				}
+			} else {
+				codes[exit.runner] = exit.code
+			}
 
-	fmt.Println()
+			ran, done := codes, len(codes) == len(w.runners)
+			if w.Once {
+				ran, done = w.once.exited(codes)
+			}
 
-	// If it's running, stop it. To not leak processes
-	w.runner.Stop()
+			if done == false {
+				continue
+			}
+
+			code := worstExitCode(ran)
+			if code != 0 && w.Once {
+				return "ran once", &ExitError{Code: code}
+			}
+
+			if code == 0 {
+				return "run succeeded", nil
+			}
+
+		case key := <-keys:
+			switch key {
+			case keyRerun:
+				for _, r := range w.runners {
+					select {
+					case r.rerun <- struct{}{}:
+					default:
+					}
+				}
+			case keyQuit:
+				return "quit requested", nil
+			case keyClear:
+				clearScreen(false)
+			case keyPause:
+				if atomic.CompareAndSwapInt32(&w.paused, 0, 1) {
+					w.Logger.Warnf("watching paused, press p to resume")
+				} else {
+					atomic.StoreInt32(&w.paused, 0)
+					w.Logger.Infof("watching resumed")
+				}
+			case keyHelp:
+				printKeyHelp(w.Logger)
+			}
+		}
+	}
+}
+
+// worstExitCode returns the exit code of a killed run if any, or else the
+// highest one
+func worstExitCode(codes map[*runner]int) int {
+	worst := 0
+	for _, code := range codes {
+		if worst < 0 {
+			break
+		}
+
+		if code < 0 || code > worst {
+			worst = code
+		}
+	}
+
+	return worst
+}
+
+// onceBatch is the runners run in the Once mode, the ones the changes that
+// come in before the first run starts are relevant for. Later changes are only
+// passed on to them, and each of them only runs once.
+type onceBatch struct {
+	mu      sync.Mutex
+	started bool
+	runners map[*runner]bool
+
+	// ran is the runners that have started their run
+	ran map[*runner]bool
+}
+
+func newOnceBatch() *onceBatch {
+	return &onceBatch{
+		runners: map[*runner]bool{},
+		ran:     map[*runner]bool{},
+	}
+}
+
+// add adds the runner to the batch, unless a run has started. Returns true if
+// the runner is in the batch.
+func (b *onceBatch) add(r *runner) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.started == false {
+		b.runners[r] = true
+	}
+
+	return b.runners[r]
+}
+
+// start returns true if the runner may start its run, once
+func (b *onceBatch) start(r *runner) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.runners[r] == false || b.ran[r] {
+		return false
+	}
 
-	colors.Blue("keyboard interrupt detected, quiting...")
+	b.started = true
+	b.ran[r] = true
 
-	return nil
+	return true
+}
+
+// exited returns the exit codes of the runners in the batch, and true once all
+// of them have exited
+func (b *onceBatch) exited(codes map[*runner]int) (map[*runner]int, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.started == false {
+		return nil, false
+	}
+
+	ran := map[*runner]int{}
+	for r := range b.runners {
+		code, ok := codes[r]
+		if !ok {
+			return nil, false
+		}
+
+		ran[r] = code
+	}
+
+	return ran, true
+}
+
+// collectChanges passes the changed files from in on to out, holding on to
+// them while out isn't ready. Files already held on to are dropped. Closes out
+// once in is closed.
+func collectChanges(ctx context.Context, in <-chan string, out chan<- string) {
+	defer close(out)
+
+	held := []string{}
+	seen := map[string]bool{}
+
+	for {
+		// Nothing is sent on a nil channel
+		var send chan<- string
+		var next string
+		if len(held) > 0 {
+			send = out
+			next = held[0]
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+
+		case filename, ok := <-in:
+			if !ok {
+				return
+			}
+
+			if seen[filename] {
+				continue
+			}
+
+			seen[filename] = true
+			held = append(held, filename)
+
+		case send <- next:
+			delete(seen, next)
+			held = held[1:]
+		}
+	}
+}
+
+// fanOut sends each event to the inboxes of the runners whose commands it's
+// relevant for
+func (w *War) fanOut(ctx context.Context, events <-chan fsnotify.Event, changes []chan string) {
+	disk := os.DirFS(w.watcher.directory)
+
+	for event := range events {
+		if atomic.LoadInt32(&w.paused) == 1 {
+			continue
+		}
+
+		// The event is ignored if none of the runners run on it
+		run := false
+		ignoredBecause, ignoredBy := "", ""
+
+		for i, r := range w.runners {
+			tpl := r.runnableTemplate
+
+			// The errors are about the event, so they are the same for all
+			// runners
+			act, reason, rule, err := decideAction(event, w.watcher.directory, tpl.Excludes, nil, tpl.Matches, nil, disk)
+			if err != nil {
+				w.Logger.Errorf("could not decide on %s: %s", event.Name, err.Error())
+				break
+			}
+
+			if act != ActionRun {
+				ignoredBecause, ignoredBy = reason, rule
+				continue
+			}
+
+			// Only the runners in the batch run in the Once mode
+			if w.once != nil && w.once.add(r) == false {
+				continue
+			}
+
+			if run == false {
+				w.watcher.hooks.OnFileChanged(event.Name, event.Op.String())
+			}
+			run = true
+
+			select {
+			case changes[i] <- event.Name:
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		if run == false && ignoredBecause != "" {
+			w.watcher.hooks.OnIgnored(event.Name, ignoredBecause, ignoredBy)
+		}
+	}
+
+	for _, c := range changes {
+		close(c)
+	}
 }
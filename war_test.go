@@ -0,0 +1,336 @@
+package war
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/doctordesh/check"
+)
+
+func TestRunStopsWhenContextIsDone(t *testing.T) {
+	w := New(t.TempDir(), shellTemplate(t, `sleep 5`), 0, time.Millisecond*10)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*300)
+	defer cancel()
+
+	err := w.Run(ctx)
+	check.OK(t, err)
+
+	// The command is stopped before Run returns
+	check.Equals(t, RunningStateStopped, w.runners[0].command.State())
+}
+
+func TestRunOnceReturnsExitError(t *testing.T) {
+	dir := t.TempDir()
+
+	w := New(dir, shellTemplate(t, `exit 3`), 0, time.Millisecond*10)
+	w.Once = true
+
+	go func() {
+		time.Sleep(time.Millisecond * 200)
+		os.WriteFile(filepath.Join(dir, "main.go"), []byte("package main"), 0666)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	err := w.Run(ctx)
+
+	var exitErr *ExitError
+	check.Assert(t, errors.As(err, &exitErr))
+	check.Equals(t, 3, exitErr.Code)
+}
+
+// recordHooks records the events it gets
+type recordHooks struct {
+	NopHooks
+
+	mu      sync.Mutex
+	changes [][]string
+	starts  int
+	exits   []RunReport
+}
+
+func (h *recordHooks) OnChange(name string, paths []string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.changes = append(h.changes, paths)
+}
+
+func (h *recordHooks) OnStart(name string, args []string, pid int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.starts++
+}
+
+func (h *recordHooks) OnExit(report RunReport) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.exits = append(h.exits, report)
+}
+
+func TestHooks(t *testing.T) {
+	dir := t.TempDir()
+	h := &recordHooks{}
+
+	w := New(dir, shellTemplate(t, `exit 3`), 0, time.Millisecond*10)
+	w.Hooks = []Hooks{h}
+	w.Once = true
+
+	go func() {
+		time.Sleep(time.Millisecond * 200)
+		os.WriteFile(filepath.Join(dir, "main.go"), []byte("package main"), 0666)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	err := w.Run(ctx)
+	check.NotOK(t, err)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	check.Equals(t, [][]string{{filepath.Join(dir, "main.go")}}, h.changes)
+	check.Equals(t, 1, h.starts)
+	check.Equals(t, 1, len(h.exits))
+	check.Equals(t, 3, h.exits[0].ExitCode)
+	check.Equals(t, []string{filepath.Join(dir, "main.go")}, h.exits[0].Files)
+}
+
+func TestRunRejectsNonPositivePollInterval(t *testing.T) {
+	for _, interval := range []time.Duration{0, -time.Second} {
+		w := New(t.TempDir(), shellTemplate(t, `exit 0`), 0, time.Millisecond*10)
+		w.Poll = true
+		w.PollInterval = interval
+
+		err := w.Run(context.Background())
+		check.NotOKWithMessage(t, err, "for interval %s", interval)
+	}
+}
+
+// orderHooks records the changes and starts of the commands in order, and the
+// changed files of each command
+type orderHooks struct {
+	NopHooks
+
+	mu      sync.Mutex
+	events  []string
+	changes map[string][]string
+}
+
+func (h *orderHooks) OnChange(name string, paths []string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.events = append(h.events, "change "+name)
+
+	for _, p := range paths {
+		if slices.Contains(h.changes[name], p) == false {
+			h.changes[name] = append(h.changes[name], p)
+		}
+	}
+}
+
+func (h *orderHooks) OnStart(name string, args []string, pid int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.events = append(h.events, "start "+name)
+}
+
+func TestRunSendsChangesToMatchingTasks(t *testing.T) {
+	dir := t.TempDir()
+	h := &orderHooks{changes: map[string][]string{}}
+
+	// The server ignores the stop signals, so stopping it takes until SIGKILL
+	server := shellTemplate(t, `trap '' INT TERM; sleep 5`)
+	server.Name = "server"
+	server.Matches = []string{"*.go"}
+	server.StopTimeout = time.Millisecond * 300
+
+	css := shellTemplate(t, `exit 0`)
+	css.Name = "css"
+	css.Matches = []string{"*.css"}
+
+	w := New(dir, server, 0, time.Millisecond*10)
+	w.AddRunnable(css, 0, time.Millisecond*10)
+	w.Hooks = []Hooks{h}
+
+	go func() {
+		time.Sleep(time.Millisecond * 200)
+		os.WriteFile(filepath.Join(dir, "main.go"), []byte("package main"), 0666)
+		time.Sleep(time.Millisecond * 100)
+		os.WriteFile(filepath.Join(dir, "util.go"), []byte("package main"), 0666)
+		time.Sleep(time.Millisecond * 100)
+		os.WriteFile(filepath.Join(dir, "style.css"), []byte("body {}"), 0666)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*1500)
+	defer cancel()
+
+	err := w.Run(ctx)
+	check.OK(t, err)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	check.Equals(t, []string{filepath.Join(dir, "main.go"), filepath.Join(dir, "util.go")}, h.changes["server"])
+	check.Equals(t, []string{filepath.Join(dir, "style.css")}, h.changes["css"])
+
+	// The css change is handled while the server is still being stopped
+	restarted := 0
+	for _, event := range h.events {
+		if event == "change css" {
+			check.EqualsWithMessage(t, 1, restarted, "the css change waited for the server to restart: %v", h.events)
+			break
+		}
+
+		if event == "start server" {
+			restarted++
+		}
+	}
+}
+
+func TestRunOnceWaitsForAllTasks(t *testing.T) {
+	dir := t.TempDir()
+	h := &recordHooks{}
+
+	w := New(dir, shellTemplate(t, `exit 0`), 0, time.Millisecond*10)
+	w.AddRunnable(shellTemplate(t, `sleep 0.3; exit 3`), 0, time.Millisecond*10)
+	w.Hooks = []Hooks{h}
+	w.Once = true
+
+	go func() {
+		time.Sleep(time.Millisecond * 200)
+		os.WriteFile(filepath.Join(dir, "main.go"), []byte("package main"), 0666)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	err := w.Run(ctx)
+
+	var exitErr *ExitError
+	check.Assert(t, errors.As(err, &exitErr))
+	check.Equals(t, 3, exitErr.Code)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	check.Equals(t, 2, len(h.exits))
+}
+
+func TestRunOnceOnlyRunsMatchingTasks(t *testing.T) {
+	dir := t.TempDir()
+	h := &recordHooks{}
+
+	goTask := shellTemplate(t, `sleep 0.3; exit 3`)
+	goTask.Matches = []string{"*.go"}
+	txtTask := shellTemplate(t, `exit 0`)
+	txtTask.Matches = []string{"*.txt"}
+
+	w := New(dir, goTask, 0, time.Millisecond*10)
+	w.AddRunnable(txtTask, 0, time.Millisecond*10)
+	w.Hooks = []Hooks{h}
+	w.Once = true
+
+	// The change to the text file comes in while the Go task runs, and doesn't
+	// start the other task
+	go func() {
+		time.Sleep(time.Millisecond * 200)
+		os.WriteFile(filepath.Join(dir, "main.go"), []byte("package main"), 0666)
+		time.Sleep(time.Millisecond * 100)
+		os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("notes"), 0666)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	err := w.Run(ctx)
+
+	var exitErr *ExitError
+	check.Assert(t, errors.As(err, &exitErr))
+	check.Equals(t, 3, exitErr.Code)
+	check.Assert(t, ctx.Err() == nil)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	check.Equals(t, 1, h.starts)
+	check.Equals(t, 1, len(h.exits))
+}
+
+func TestRunExitOnSuccessWaitsForAllTasks(t *testing.T) {
+	dir := t.TempDir()
+	h := &recordHooks{}
+
+	// The second task fails until the marker exists
+	w := New(dir, shellTemplate(t, `exit 0`), 0, time.Millisecond*10)
+	w.AddRunnable(shellTemplate(t, `test -f `+filepath.Join(dir, "marker")), 0, time.Millisecond*10)
+	w.Hooks = []Hooks{h}
+	w.ExitOnSuccess = true
+
+	go func() {
+		time.Sleep(time.Millisecond * 300)
+		os.WriteFile(filepath.Join(dir, "marker"), nil, 0666)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	err := w.Run(ctx)
+	check.OK(t, err)
+	check.Assert(t, ctx.Err() == nil)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	// Run returned after the second task succeeded
+	succeeded := false
+	for _, report := range h.exits {
+		if strings.Contains(report.Command, "test -f") && report.ExitCode == 0 {
+			succeeded = true
+		}
+	}
+	check.Assert(t, succeeded)
+}
+
+func TestWorstExitCode(t *testing.T) {
+	type row struct {
+		Codes    []int
+		Expected int
+	}
+
+	table := []row{
+		{[]int{0, 0}, 0},
+		{[]int{0, 3}, 3},
+		{[]int{2, 0, 5}, 5},
+		{[]int{2, -1, 5}, -1},
+	}
+
+	for _, row := range table {
+		codes := map[*runner]int{}
+		for _, code := range row.Codes {
+			codes[&runner{}] = code
+		}
+
+		check.EqualsWithMessage(t, row.Expected, worstExitCode(codes), "for codes %v", row.Codes)
+	}
+}
+
+func TestRunWithoutLogger(t *testing.T) {
+	w := New(t.TempDir(), shellTemplate(t, `exit 0`), 0, time.Millisecond*10)
+	w.Logger = nil
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*200)
+	defer cancel()
+
+	err := w.Run(ctx)
+	check.OK(t, err)
+}
@@ -1,79 +1,134 @@
 package war
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io/fs"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
+	"syscall"
+	"time"
 
-	"github.com/doctordesh/war/colors"
 	"github.com/fsnotify/fsnotify"
 )
 
 type watcher struct {
 	directory string
-	// match     []string
-	exclude []string
+	ignorer   Ignorer
+	hooks     Hooks
+	log       Logger
+
+	// excludes are the excluded paths of each task. A directory is only
+	// skipped if all tasks exclude it, the tasks filter the events themselves.
+	excludes [][]string
+
 	verbose bool
+
+	// poll makes the watcher use the polling backend instead of inotify
+	poll         bool
+	pollInterval time.Duration
 }
 
 func (w *watcher) SetVerboseLogging(b bool) {
 	w.verbose = b
 }
 
-func (w *watcher) Watch() (<-chan string, error) {
-	c := make(chan string)
-
-	notify, err := fsnotify.NewWatcher()
-	if err != nil {
-		return c, err
-	}
+// Watch watches the directory and all its sub directories, and sends the
+// events that should trigger a run on the returned channel. The events channel
+// is closed when ctx is done or watching fails, in which case the error is
+// sent on the errors channel.
+func (w *watcher) Watch(ctx context.Context) (<-chan fsnotify.Event, <-chan error, error) {
+	c := make(chan fsnotify.Event)
+	errs := make(chan error, 1)
 
 	// Find all sub directories
-	dirs, err := w.allDirs(w.directory)
+	dirs, skipped, err := w.allDirs(w.directory)
 	if err != nil {
-		return c, err
+		return nil, nil, err
 	}
 
 	dirs = append(dirs, w.directory)
 
 	if w.verbose {
 		for _, d := range dirs {
-			colors.Blue("watching %s", d)
+			w.log.Infof("watching %s", d)
 		}
 	} else {
-		colors.Blue("watching %s", w.directory)
+		w.log.Infof("watching %s", w.directory)
+	}
+
+	if skipped > 0 {
+		w.log.Infof("skipped %d excluded directories", skipped)
+	}
+
+	notify, err := w.backend(dirs)
+	if err != nil {
+		return nil, nil, err
 	}
 
 	go func() {
+		defer close(c)
+		defer notify.Close()
+
 		for {
 			select {
-			case event, ok := <-notify.Events:
+			case <-ctx.Done():
+				return
+
+			case event, ok := <-notify.Events():
 				if !ok {
-					colors.Red("unexpected error from notifier, could not read events")
-					os.Exit(2)
+					errs <- fmt.Errorf("notifier closed unexpectedly, could not read events")
+					return
 				}
 
-				act, err := DecideAction(event, w.directory, w.exclude, nil, os.DirFS(w.directory))
+				if w.ignorer != nil {
+					if rel, err := filepath.Rel(w.directory, event.Name); err == nil {
+						w.ignorer.Changed(rel)
+					}
+				}
+
+				act, reason, rule, err := decideAction(event, w.directory, nil, nil, nil, w.ignorer, os.DirFS(w.directory))
 				if err != nil {
-					colors.Red("could not decide on %s: %s", event.Name, err.Error())
-					os.Exit(2)
+					w.log.Errorf("could not decide on %s: %s", event.Name, err.Error())
+					continue
 				}
 
 				switch act {
 				case ActionIgnore:
+					w.hooks.OnIgnored(event.Name, reason, rule)
 					continue
 				case ActionRun:
-					c <- event.Name
+					select {
+					case c <- event:
+					case <-ctx.Done():
+						return
+					}
 				case ActionAdd:
-					colors.Blue("new directory detected %s", event.Name)
-					err = notify.Add(event.Name)
+					if w.shouldIgnore(event.Name) {
+						w.hooks.OnIgnored(event.Name, ReasonExcluded, "")
+						continue
+					}
+
+					// The directory may have been created with content, for
+					// example by a 'git checkout' or 'mkdir -p'
+					subDirs, skipped, err := w.allDirs(event.Name)
 					if err != nil {
-						colors.Red("could not add %s to notifier: %v", event.Name, err)
-						os.Exit(2)
+						w.log.Errorf("could not read new directory %s: %v", event.Name, err)
+						continue
 					}
 
+					if skipped > 0 {
+						w.log.Infof("skipped %d excluded directories in %s", skipped, event.Name)
+					}
+
+					err = w.watchNewDirs(notify, append([]string{event.Name}, subDirs...))
+					if err != nil {
+						errs <- err
+						return
+					}
 				}
 
 				// // Not interested in chmods
@@ -118,35 +173,93 @@ func (w *watcher) Watch() (<-chan string, error) {
 				// 	continue
 				// }
 
-				// if w.isMatch(event.Name, w.match) == false {
-				// 	continue
-				// }
-
 				// c <- event.Name
 
-			case err, _ := <-notify.Errors:
-				colors.Red("unexpected error from notifier: %v", err)
-				os.Exit(2)
+			case err, _ := <-notify.Errors():
+				errs <- fmt.Errorf("unexpected error from notifier: %w", err)
+				return
 			}
 		}
 	}()
 
+	return c, errs, nil
+}
+
+// watchNewDirs adds the new directories to the backend. A directory that is
+// gone before it's added is skipped, and once the inotify watch limit is
+// reached the rest are, which is logged. Other errors are returned.
+func (w *watcher) watchNewDirs(notify backend, dirs []string) error {
+	for i, d := range dirs {
+		err := notify.Add(d)
+		if errors.Is(err, fs.ErrNotExist) {
+			w.log.Warnf("new directory %s is gone, not watching it", d)
+			continue
+		}
+
+		if errors.Is(err, syscall.ENOSPC) {
+			w.log.Errorf("could not watch %s and %d more new directories, the inotify watch limit is reached. Raise fs.inotify.max_user_watches, or poll for changes instead", d, len(dirs)-i-1)
+			return nil
+		}
+
+		if err != nil {
+			return fmt.Errorf("could not add %s to notifier: %w", d, err)
+		}
+
+		w.hooks.OnDirectoryAdded(d)
+	}
+
+	return nil
+}
+
+// backend returns a backend watching dirs. Unless polling is requested it
+// uses inotify, falling back to polling if the directories can't be added,
+// which happens when the inotify watch limit is reached.
+func (w *watcher) backend(dirs []string) (backend, error) {
+	if w.poll == false {
+		notify, err := newFsnotifyBackend()
+		if err == nil {
+			err = addDirs(notify, dirs)
+			if err == nil {
+				return notify, nil
+			}
+
+			notify.Close()
+		}
+
+		w.log.Warnf("could not watch with inotify (%v), falling back to polling every %s", err, w.pollInterval)
+	}
+
+	notify := newPollBackend(w.pollInterval)
+
+	err := addDirs(notify, dirs)
+	if err != nil {
+		notify.Close()
+		return nil, err
+	}
+
+	return notify, nil
+}
+
+func addDirs(b backend, dirs []string) error {
 	for _, d := range dirs {
-		err = notify.Add(d)
+		err := b.Add(d)
 		if err != nil {
-			return c, fmt.Errorf("watcher could not add directory %s to notifier: %w", d, err)
+			return fmt.Errorf("watcher could not add directory %s to notifier: %w", d, err)
 		}
 	}
 
-	return c, nil
+	return nil
 }
 
-func (w *watcher) allDirs(dir string) ([]string, error) {
+// allDirs returns all sub directories of dir that should be watched, and the
+// number of directories that were skipped. Skipped directories are not walked.
+func (w *watcher) allDirs(dir string) ([]string, int, error) {
 	dirs := []string{}
+	skipped := 0
 
 	files, err := ioutil.ReadDir(dir)
 	if err != nil {
-		return dirs, err
+		return dirs, skipped, err
 	}
 
 	for _, f := range files {
@@ -157,8 +270,9 @@ func (w *watcher) allDirs(dir string) ([]string, error) {
 		// build subdir path
 		subDirPath := filepath.Join(dir, f.Name())
 
-		// filter . files
-		if w.isDotFile(subDirPath) {
+		// filter . files and excluded directories
+		if w.shouldIgnore(subDirPath) {
+			skipped++
 			continue
 		}
 
@@ -166,15 +280,31 @@ func (w *watcher) allDirs(dir string) ([]string, error) {
 		dirs = append(dirs, subDirPath)
 
 		// Fetch all sub dirs from the found dir
-		subDirs, err := w.allDirs(subDirPath)
+		subDirs, subSkipped, err := w.allDirs(subDirPath)
+		skipped += subSkipped
 		if err != nil {
-			return dirs, err
+			return dirs, skipped, err
 		}
 
 		dirs = append(dirs, subDirs...)
 	}
 
-	return dirs, nil
+	return dirs, skipped, nil
+}
+
+// excludedByAll returns true if all tasks exclude the directory at rel
+func (w *watcher) excludedByAll(rel string) bool {
+	if len(w.excludes) == 0 {
+		return false
+	}
+
+	for _, exclude := range w.excludes {
+		if ignored, _ := excludeRules(exclude).ignores(rel, true); ignored == false {
+			return false
+		}
+	}
+
+	return true
 }
 
 func (w *watcher) isDir(path string) bool {
@@ -186,12 +316,26 @@ func (w *watcher) isDir(path string) bool {
 	return fileInfo.IsDir()
 }
 
-// func (w *watcher) isMatch(path string, match []string) bool {
-// 	return true
-// }
-
+// shouldIgnore returns true if the directory at path should not be watched,
+// either because it's a dot directory or because the exclusion rules ignore it
 func (w *watcher) shouldIgnore(path string) bool {
-	fmt.Println(path, w.exclude)
+	rel, err := filepath.Rel(w.directory, path)
+	if err != nil {
+		return false
+	}
+
+	if w.isDotFile(rel) {
+		return true
+	}
+
+	if w.excludedByAll(rel) {
+		return true
+	}
+
+	if w.ignorer != nil && w.ignorer.Ignore(rel, true) {
+		return true
+	}
+
 	return false
 }
 
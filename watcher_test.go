@@ -0,0 +1,85 @@
+package war
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"syscall"
+	"testing"
+
+	"github.com/doctordesh/check"
+	"github.com/doctordesh/war/colors"
+)
+
+func TestAllDirsSkipsExcluded(t *testing.T) {
+	var err error
+
+	base := t.TempDir()
+	for _, d := range []string{
+		"cmd/war",
+		"node_modules/pkg/lib",
+		"sub/node_modules/pkg",
+		".git/objects",
+		"bin",
+		"sub/bin",
+		"build/out",
+	} {
+		err = os.MkdirAll(filepath.Join(base, d), 0777)
+		check.OK(t, err)
+	}
+
+	err = os.WriteFile(filepath.Join(base, ".gitignore"), []byte("build/\n"), 0666)
+	check.OK(t, err)
+
+	w := &watcher{
+		directory: base,
+		excludes:  [][]string{{"/node_modules", "bin"}, {"/node_modules", "bin", "cmd"}},
+		ignorer:   newGitIgnore(os.DirFS(base)),
+	}
+
+	dirs, skipped, err := w.allDirs(base)
+	check.OK(t, err)
+
+	for i := range dirs {
+		dirs[i], err = filepath.Rel(base, dirs[i])
+		check.OK(t, err)
+	}
+	sort.Strings(dirs)
+
+	check.Equals(t, []string{"cmd", "cmd/war", "sub", "sub/node_modules", "sub/node_modules/pkg"}, dirs)
+	check.Equals(t, 5, skipped)
+}
+
+// failingBackend fails to add the directories in errs
+type failingBackend struct {
+	backend
+	errs  map[string]error
+	added []string
+}
+
+func (b *failingBackend) Add(dir string) error {
+	if err := b.errs[dir]; err != nil {
+		return err
+	}
+
+	b.added = append(b.added, dir)
+	return nil
+}
+
+func TestWatchNewDirsSkipsGoneDirsAndWatchLimit(t *testing.T) {
+	w := &watcher{hooks: NopHooks{}, log: colors.NewLogger(io.Discard, false)}
+
+	b := &failingBackend{errs: map[string]error{
+		"/base/gone":  syscall.ENOENT,
+		"/base/limit": syscall.ENOSPC,
+	}}
+
+	err := w.watchNewDirs(b, []string{"/base/a", "/base/gone", "/base/b", "/base/limit", "/base/c"})
+	check.OK(t, err)
+	check.Equals(t, []string{"/base/a", "/base/b"}, b.added)
+
+	b = &failingBackend{errs: map[string]error{"/base/a": syscall.EACCES}}
+	err = w.watchNewDirs(b, []string{"/base/a"})
+	check.NotOK(t, err)
+}